@@ -24,6 +24,7 @@ document.getElementById('login-form').addEventListener('submit', async (event) =
 async function createVideoDraft() {
   const title = document.getElementById('video-title').value;
   const description = document.getElementById('video-description').value;
+  const visibility = document.getElementById('video-visibility').value;
 
   try {
     const res = await fetch('/api/videos', {
@@ -32,7 +33,7 @@ async function createVideoDraft() {
         'Content-Type': 'application/json',
         Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
-      body: JSON.stringify({ title, description }),
+      body: JSON.stringify({ title, description, visibility }),
     });
     const data = await res.json();
     if (!res.ok) {
@@ -61,11 +62,15 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
+    if (data.totp_required) {
+      data = await loginTOTP(data.challenge_token);
+    }
+
     if (data.token) {
       localStorage.setItem('token', data.token);
       document.getElementById('auth-section').style.display = 'none';
@@ -79,6 +84,26 @@ async function login() {
   }
 }
 
+async function loginTOTP(challengeToken) {
+  const code = prompt('Enter the code from your authenticator app, or a recovery code');
+  if (!code) {
+    throw new Error('Login cancelled');
+  }
+
+  const res = await fetch('/api/login/totp', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ challenge_token: challengeToken, code }),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  return data;
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -170,12 +195,18 @@ async function uploadVideoFile(videoID) {
       },
       body: formData,
     });
+    const data = await res.json();
     if (!res.ok) {
-      const data = await res.json();
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log('Video uploaded!');
+    console.log('Video uploaded, processing...');
+    document.getElementById(uploadBtnSelector).textContent = 'Processing...';
+    const job = await waitForJob(data.id);
+    if (job.status === 'failed') {
+      throw new Error(`Video processing failed. Error: ${job.last_error}`);
+    }
+    console.log('Video processed!');
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -184,22 +215,50 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
-const videoStateHandler = createVideoStateHandler();
-
-async function getVideos() {
-  try {
-    const res = await fetch('/api/videos', {
+async function waitForJob(jobID) {
+  while (true) {
+    const res = await fetch(`/api/jobs/${jobID}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
     });
+    const job = await res.json();
     if (!res.ok) {
-      const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
+      throw new Error(`Failed to get processing job. Error: ${job.error}`);
     }
+    if (job.status === 'completed' || job.status === 'failed') {
+      return job;
+    }
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
+const videoStateHandler = createVideoStateHandler();
+
+async function getVideos() {
+  try {
+    const videos = [];
+    let cursor = '';
+    do {
+      const params = new URLSearchParams({ limit: '100' });
+      if (cursor) {
+        params.set('cursor', cursor);
+      }
+      const res = await fetch(`/api/videos?${params}`, {
+        method: 'GET',
+        headers: {
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
+        },
+      });
+      const data = await res.json();
+      if (!res.ok) {
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+      videos.push(...data.videos);
+      cursor = data.next_cursor;
+    } while (cursor);
 
-    const videos = await res.json();
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
@@ -249,6 +308,7 @@ async function getVideo(videoID) {
 }
 
 let currentVideo = null;
+let hlsPlayer = null;
 
 function viewVideo(video) {
   currentVideo = video;
@@ -257,17 +317,32 @@ function viewVideo(video) {
   document.getElementById('video-description-display').textContent = video.description;
 
   const thumbnailImg = document.getElementById('thumbnail-image');
-  if (!video.thumbnail_url) {
+  if (!video.thumbnail) {
     thumbnailImg.style.display = 'none';
   } else {
     thumbnailImg.style.display = 'block';
-    thumbnailImg.src = video.thumbnail_url;
+    thumbnailImg.srcset = video.thumbnail.webp_srcset || video.thumbnail.jpeg_srcset;
+    thumbnailImg.sizes = '(max-width: 640px) 100vw, 640px';
+    thumbnailImg.src = video.thumbnail.src;
   }
 
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
-    if (!video.video_url) {
+    if (hlsPlayer) {
+      hlsPlayer.destroy();
+      hlsPlayer = null;
+    }
+    if (!video.video_url && !video.hls_manifest_url) {
       videoPlayer.style.display = 'none';
+    } else if (video.hls_manifest_url && window.Hls && Hls.isSupported()) {
+      videoPlayer.style.display = 'block';
+      hlsPlayer = new Hls();
+      hlsPlayer.loadSource(video.hls_manifest_url);
+      hlsPlayer.attachMedia(videoPlayer);
+    } else if (video.hls_manifest_url && videoPlayer.canPlayType('application/vnd.apple.mpegurl')) {
+      videoPlayer.style.display = 'block';
+      videoPlayer.src = video.hls_manifest_url;
+      videoPlayer.load();
     } else {
       videoPlayer.style.display = 'block';
       videoPlayer.src = video.video_url;
@@ -1,12 +1,181 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/delivery"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 )
 
-func (cfg apiConfig) ensureAssetsDir() error {
+func (cfg *apiConfig) ensureAssetsDir() error {
 	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
 		return os.Mkdir(cfg.assetsRoot, 0755)
 	}
 	return nil
 }
+
+// handlerAssetsGet serves objects straight out of the storage backend. It is
+// only mounted for the local and memory backends; S3 objects are served by
+// CloudFront. Without a signature an object is only served when the caller
+// may see the video it belongs to, since stream keys can be guessed from the
+// video ID.
+func (cfg *apiConfig) handlerAssetsGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	video, err := cfg.db.GetVideoByAssetKey(key)
+	if errors.Is(err, database.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if !canViewVideo(video, principalFromContext(r.Context()).UserID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	cfg.serveAsset(w, r, key)
+}
+
+// handlerAssetsGetSigned serves objects through URLs signed by the base URL
+// resolver. They are only handed out to callers allowed to see the video,
+// and work for anyone until they expire, like signed CDN URLs.
+func (cfg *apiConfig) handlerAssetsGetSigned(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	resolver, ok := cfg.urls.(*delivery.BaseURLResolver)
+	expires, err := strconv.ParseInt(r.PathValue("expires"), 10, 64)
+	if !ok || err != nil || !resolver.Verify(key, expires, r.PathValue("signature")) {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired asset URL", err)
+		return
+	}
+
+	cfg.serveAsset(w, r, key)
+}
+
+func (cfg *apiConfig) serveAsset(w http.ResponseWriter, r *http.Request, key string) {
+	body, obj, err := cfg.storage.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read asset", err)
+		return
+	}
+	defer body.Close()
+
+	if obj.ContentType != "" {
+		w.Header().Set("Content-Type", obj.ContentType)
+	}
+
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, obj.LastModified, seeker)
+		return
+	}
+	io.Copy(w, body)
+}
+
+// resolveVideoURLs fills in a video's URL fields from its stored buckets and
+// keys, using the configured delivery mode.
+func (cfg *apiConfig) resolveVideoURLs(ctx context.Context, video *database.Video) error {
+	bucket := ""
+	if video.VideoBucket != nil {
+		bucket = *video.VideoBucket
+	}
+	resolve := func(key *string) (*string, error) {
+		if key == nil {
+			return nil, nil
+		}
+		u, err := cfg.urls.URL(ctx, bucket, *key)
+		if err != nil {
+			return nil, err
+		}
+		return &u, nil
+	}
+
+	var err error
+	if video.VideoURL, err = resolve(video.VideoKey); err != nil {
+		return err
+	}
+	if cfg.deliveryMode == "presigned" {
+		// Players would get 403s for the unsigned segments, so streams
+		// packaged under another delivery mode aren't offered
+		video.StreamingFormats = slices.DeleteFunc(video.StreamingFormats, func(format string) bool {
+			return format != "mp4"
+		})
+	} else {
+		if video.HLSManifestURL, err = resolve(video.HLSManifestKey); err != nil {
+			return err
+		}
+		if video.DASHManifestURL, err = resolve(video.DASHManifestKey); err != nil {
+			return err
+		}
+	}
+
+	if video.Thumbnail == nil {
+		return nil
+	}
+	thumb := video.Thumbnail
+	if thumb.Src, err = cfg.urls.URL(ctx, thumb.Bucket, thumb.SrcKey); err != nil {
+		return err
+	}
+	for i := range thumb.Sizes {
+		size := &thumb.Sizes[i]
+		if size.JPEGKey != "" {
+			if size.JPEG, err = cfg.urls.URL(ctx, thumb.Bucket, size.JPEGKey); err != nil {
+				return err
+			}
+		}
+		if size.WebPKey != "" {
+			if size.WebP, err = cfg.urls.URL(ctx, thumb.Bucket, size.WebPKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setDeliveryCookies hands out CloudFront signed cookies for the video's
+// stream when they are enabled, so players can fetch its segments. They are
+// only set for one video at a time: each takes three cookies, and listings
+// would soon go past what browsers keep. Callers must only pass videos the
+// requester is allowed to see.
+func (cfg *apiConfig) setDeliveryCookies(w http.ResponseWriter, video database.Video) error {
+	if cfg.deliveryMode != "signed" || cfg.cookieDomain == "" {
+		return nil
+	}
+	signed, ok := cfg.urls.(*delivery.SignedCDNResolver)
+	if !ok {
+		return nil
+	}
+	manifestKey := video.HLSManifestKey
+	if manifestKey == nil {
+		manifestKey = video.DASHManifestKey
+	}
+	if manifestKey == nil {
+		return nil
+	}
+	cookies, err := signed.Cookies(path.Dir(*manifestKey) + "/")
+	if err != nil {
+		return err
+	}
+	for _, cookie := range cookies {
+		cookie.Domain = cfg.cookieDomain
+		cookie.Secure = true
+		cookie.HttpOnly = true
+		cookie.SameSite = http.SameSiteNoneMode
+		http.SetCookie(w, cookie)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerAssetsGet(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	uploadKey := api.createAPIKey(t, owner, database.ScopeVideosUpload)
+
+	withFile := func(title string, visibility database.Visibility) (database.Video, string) {
+		t.Helper()
+		video := api.createVideo(t, owner, title, visibility)
+		key := "landscape/" + video.ID.String() + ".mp4"
+		video.VideoKey = &key
+		if err := api.db.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+		if err := api.storage.Put(context.Background(), key, strings.NewReader(title), "video/mp4"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		return video, "/assets/" + key
+	}
+	_, public := withFile("public", database.VisibilityPublic)
+	private, privateAsset := withFile("private", database.VisibilityPrivate)
+	if err := api.storage.Put(context.Background(), "landscape/orphan.mp4", strings.NewReader("orphan"), "video/mp4"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	runStatusCases(t, api, []statusCase{
+		{"public", "GET", public, "", nil, http.StatusOK},
+		{"private, owner", "GET", privateAsset, owner.token, nil, http.StatusOK},
+		{"invalid token", "GET", public, "not-a-jwt", nil, http.StatusUnauthorized},
+		{"upload-only API key", "GET", public, uploadKey, nil, http.StatusForbidden},
+		{"private, anonymous", "GET", privateAsset, "", nil, http.StatusNotFound},
+		{"private, other user", "GET", privateAsset, other.token, nil, http.StatusNotFound},
+		{"not a video's file", "GET", "/assets/landscape/orphan.mp4", "", nil, http.StatusNotFound},
+		{"unknown", "GET", "/assets/landscape/unknown.mp4", "", nil, http.StatusNotFound},
+	})
+
+	rec := api.do("GET", public, "", nil)
+	if rec.Body.String() != "public" || rec.Header().Get("Content-Type") != "video/mp4" {
+		t.Errorf("served %q as %q, want the stored file", rec.Body, rec.Header().Get("Content-Type"))
+	}
+
+	// Signed URLs work for anyone, but only for the key they were signed for
+	got := decodeBody[database.Video](t, api.do("GET", "/api/videos/"+private.ID.String(), owner.token, nil))
+	if got.VideoURL == nil {
+		t.Fatal("video has no URL")
+	}
+	signed, err := url.Parse(*got.VideoURL)
+	if err != nil {
+		t.Fatalf("video URL doesn't parse: %v", err)
+	}
+	runStatusCases(t, api, []statusCase{
+		{"signed, anonymous", "GET", signed.Path, "", nil, http.StatusOK},
+		{"signed for another key", "GET", strings.Replace(signed.Path, private.ID.String(), "orphan", 1), "", nil, http.StatusForbidden},
+		{"bad signature", "GET", "/assets/t/9999999999/AAAA/" + strings.TrimPrefix(privateAsset, "/assets/"), "", nil, http.StatusForbidden},
+	})
+}
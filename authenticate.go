@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// principal is the user a request was made by.
+type principal struct {
+	UserID uuid.UUID
+	// Role is the role claimed by the access token, empty for API keys and
+	// older tokens. can replaces it with the user's current role.
+	Role database.Role
+	// APIKeyID is set when the request was authenticated with an API key,
+	// and Scopes to what the key was granted.
+	APIKeyID uuid.UUID
+	Scopes   []database.APIKeyScope
+}
+
+// authError is an authentication failure to report to the client with
+// status. Other errors from authenticate are server errors.
+type authError struct {
+	status int
+	msg    string
+	err    error
+}
+
+func (e *authError) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	var authErr *authError
+	if errors.As(err, &authErr) {
+		respondWithError(w, authErr.status, authErr.msg, authErr.err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't authenticate", err)
+}
+
+// authenticate returns who made a request, from either an
+// "Authorization: Bearer <access token>" or an "Authorization: ApiKey <key>"
+// header.
+//
+// There is deliberately no session source. Tubely has no session cookies:
+// the app keeps its tokens in localStorage and sends them as headers, which
+// also keeps cookie-based CSRF off the table. The closest thing to a
+// session, the refresh token, is only accepted by /api/refresh, so a leaked
+// access token expires instead of lasting as long as the refresh token.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		return cfg.authenticateAPIKey(r)
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return principal{}, &authError{http.StatusUnauthorized, "Couldn't find JWT or API key", err}
+	}
+	claims, err := auth.ParseJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return principal{}, &authError{http.StatusUnauthorized, "Couldn't validate JWT", err}
+	}
+	user, err := cfg.db.GetUser(claims.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return principal{}, &authError{http.StatusUnauthorized, "User no longer exists", err}
+	}
+	if err != nil {
+		return principal{}, fmt.Errorf("couldn't get user: %w", err)
+	}
+	if claims.TokenVersion != user.TokenVersion {
+		return principal{}, &authError{http.StatusUnauthorized, "Access token has been revoked", nil}
+	}
+	return principal{UserID: claims.UserID, Role: database.Role(claims.Role)}, nil
+}
+
+// authenticateAPIKey records that the key was used. The route's scope
+// requirement decides whether the key may call it.
+func (cfg *apiConfig) authenticateAPIKey(r *http.Request) (principal, error) {
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return principal{}, &authError{http.StatusUnauthorized, "Couldn't find API key", err}
+	}
+	apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashToken(key))
+	if errors.Is(err, database.ErrNotFound) {
+		return principal{}, &authError{http.StatusUnauthorized, "Invalid API key", err}
+	}
+	if err != nil {
+		return principal{}, fmt.Errorf("couldn't get API key: %w", err)
+	}
+	if apiKey.RevokedAt != nil {
+		return principal{}, &authError{http.StatusUnauthorized, "API key has been revoked", nil}
+	}
+
+	err = cfg.db.MarkAPIKeyUsed(apiKey.ID)
+	if err != nil {
+		return principal{}, fmt.Errorf("couldn't update API key: %w", err)
+	}
+	return principal{UserID: apiKey.UserID, APIKeyID: apiKey.ID, Scopes: apiKey.Scopes}, nil
+}
+
+type principalContextKey struct{}
+
+type videoContextKey struct{}
+
+type scopeCheckedContextKey struct{}
+
+// principalFromContext returns the caller stored by withAuth. Its UserID is
+// uuid.Nil for anonymous requests.
+func principalFromContext(ctx context.Context) principal {
+	p, _ := ctx.Value(principalContextKey{}).(principal)
+	return p
+}
+
+// videoFromContext returns the video loaded by the ownsVideo requirement.
+func videoFromContext(ctx context.Context) database.Video {
+	video, _ := ctx.Value(videoContextKey{}).(database.Video)
+	return video
+}
+
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p))
+}
+
+// authRequirement is a rule a route declares about who may call it. It
+// responds and returns false when the request may not go on, and may return
+// the request with more in its context.
+type authRequirement func(cfg *apiConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool)
+
+// withAuth resolves the caller and checks reqs in order before calling next,
+// which reads the caller with principalFromContext. Without reqs the route
+// is public: anonymous requests get through, but credentials that were sent
+// must be valid. API keys are rejected unless reqs include scope.
+func (cfg *apiConfig) withAuth(next http.HandlerFunc, reqs ...authRequirement) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var caller principal
+		if r.Header.Get("Authorization") != "" {
+			var err error
+			caller, err = cfg.authenticate(r)
+			if err != nil {
+				respondWithAuthError(w, err)
+				return
+			}
+		}
+		r = withPrincipal(r, caller)
+
+		for _, req := range reqs {
+			var ok bool
+			r, ok = req(cfg, w, r)
+			if !ok {
+				return
+			}
+		}
+		// API keys only reach routes that name the scopes they accept
+		if caller.APIKeyID != uuid.Nil && r.Context().Value(scopeCheckedContextKey{}) == nil {
+			respondWithError(w, http.StatusForbidden, "This can't be done with an API key", nil)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// signedIn rejects anonymous requests.
+func signedIn(cfg *apiConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if principalFromContext(r.Context()).UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT or API key", auth.ErrNoAuthHeaderIncluded)
+		return r, false
+	}
+	return r, true
+}
+
+// scope lets API keys holding any of scopes call the route. Other callers
+// are unaffected.
+func scope(scopes ...database.APIKeyScope) authRequirement {
+	return func(cfg *apiConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+		caller := principalFromContext(r.Context())
+		if caller.APIKeyID != uuid.Nil && !slices.ContainsFunc(scopes, func(s database.APIKeyScope) bool {
+			return slices.Contains(caller.Scopes, s)
+		}) {
+			msg := fmt.Sprintf("API key needs one of the scopes %v", scopes)
+			respondWithError(w, http.StatusForbidden, msg, nil)
+			return r, false
+		}
+		return r.WithContext(context.WithValue(r.Context(), scopeCheckedContextKey{}, true)), true
+	}
+}
+
+// loginOnly rejects API keys, for routes a leaked key mustn't reach.
+func loginOnly(cfg *apiConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	r, ok := signedIn(cfg, w, r)
+	if !ok {
+		return r, false
+	}
+	if principalFromContext(r.Context()).APIKeyID != uuid.Nil {
+		respondWithError(w, http.StatusForbidden, "This can't be done with an API key", nil)
+		return r, false
+	}
+	return r, true
+}
+
+// can rejects callers whose current role doesn't grant perm.
+func can(perm permission) authRequirement {
+	return func(cfg *apiConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+		r, ok := signedIn(cfg, w, r)
+		if !ok {
+			return r, false
+		}
+
+		// The role claim is only as fresh as the token, and a demoted user
+		// must lose their permissions right away
+		caller := principalFromContext(r.Context())
+		user, err := cfg.db.GetUser(caller.UserID)
+		if errors.Is(err, database.ErrNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "User no longer exists", err)
+			return r, false
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return r, false
+		}
+		caller.Role = user.Role
+		r = withPrincipal(r, caller)
+		if !roleCan(caller.Role, perm) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do this", nil)
+			return r, false
+		}
+		return r, true
+	}
+}
+
+// ownsVideo loads the video named by the videoID path value and rejects
+// everyone but its owner. Handlers read it with videoFromContext.
+func ownsVideo(cfg *apiConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	r, ok := signedIn(cfg, w, r)
+	if !ok {
+		return r, false
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return r, false
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return r, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return r, false
+	}
+	if video.UserID != principalFromContext(r.Context()).UserID {
+		respondWithError(w, http.StatusForbidden, "You don't own this video", nil)
+		return r, false
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), videoContextKey{}, video)), true
+}
+
+// verifiedEmail rejects users who haven't verified their email address.
+func verifiedEmail(cfg *apiConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	r, ok := signedIn(cfg, w, r)
+	if !ok {
+		return r, false
+	}
+
+	user, err := cfg.db.GetUser(principalFromContext(r.Context()).UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists", err)
+		return r, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return r, false
+	}
+	if user.EmailVerifiedAt == nil {
+		respondWithError(w, http.StatusForbidden, "Verify your email address first", nil)
+		return r, false
+	}
+	return r, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+	"github.com/google/uuid"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// appLink returns a link to the app with token in the query parameter param.
+func (cfg *apiConfig) appLink(param, token string) string {
+	u := *cfg.appURL
+	q := u.Query()
+	q.Set(param, token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// newEmailToken stores a single-use token for purpose and returns it. Only
+// its hash is kept.
+func (cfg *apiConfig) newEmailToken(userID uuid.UUID, purpose database.EmailTokenPurpose, ttl time.Duration) (string, error) {
+	token, err := auth.MakeEmailToken()
+	if err != nil {
+		return "", err
+	}
+	err = cfg.db.CreateEmailToken(database.CreateEmailTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.newEmailToken(user.ID, database.EmailTokenVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body: fmt.Sprintf(
+			"Open this link to verify your email address:\n\n%s\n\nIt expires in 48 hours. If you didn't sign up for Tubely, you can ignore this email.\n",
+			cfg.appLink("verify_email", token),
+		),
+	})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.newEmailToken(user.ID, database.EmailTokenResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Open this link to choose a new password:\n\n%s\n\nIt expires in an hour. If you didn't ask for this, you can ignore this email.\n",
+			cfg.appLink("reset_password", token),
+		),
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get users", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func (cfg *apiConfig) handlerAdminUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role database.Role `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Role must be viewer, creator, moderator or admin", nil)
+		return
+	}
+
+	// Admins demoting themselves could leave nobody to manage users
+	adminID := principalFromContext(r.Context()).UserID
+	if userID == adminID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role", nil)
+		return
+	}
+
+	err = cfg.db.UpdateUserRole(userID, params.Role)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	log.Printf("Admin %s made user %s a %s", adminID, userID, params.Role)
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	err = cfg.db.DeleteVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	cfg.deleteVideoFiles(context.WithoutCancel(r.Context()), video)
+	log.Printf("Moderator %s deleted video %s", principalFromContext(r.Context()).UserID, videoID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminVideoHide(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoHidden(w, r, true)
+}
+
+func (cfg *apiConfig) handlerAdminVideoUnhide(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoHidden(w, r, false)
+}
+
+func (cfg *apiConfig) setVideoHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	err = cfg.db.SetVideoHidden(videoID, hidden)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.Hidden = hidden
+	log.Printf("Moderator %s set video %s hidden=%t", principalFromContext(r.Context()).UserID, videoID, hidden)
+
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
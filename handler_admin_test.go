@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerAdminUsers(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.createUser(t, "admin@example.com", database.RoleAdmin)
+	moderator := api.createUser(t, "moderator@example.com", database.RoleModerator)
+	user := api.createUser(t, "user@example.com", database.RoleCreator)
+	adminKey := api.createAPIKey(t, admin, database.ScopeVideosRead, database.ScopeVideosUpload, database.ScopeVideosWrite)
+	target := "/admin/users/" + user.ID.String() + "/role"
+
+	runStatusCases(t, api, []statusCase{
+		{"list", "GET", "/admin/users", admin.token, nil, http.StatusOK},
+		{"list anonymously", "GET", "/admin/users", "", nil, http.StatusUnauthorized},
+		{"list as moderator", "GET", "/admin/users", moderator.token, nil, http.StatusForbidden},
+		{"list with an API key", "GET", "/admin/users", adminKey, nil, http.StatusForbidden},
+		{"set role", "PUT", target, admin.token, map[string]string{"role": "viewer"}, http.StatusOK},
+		{"set unknown role", "PUT", target, admin.token, map[string]string{"role": "owner"}, http.StatusBadRequest},
+		{"set role malformed body", "PUT", target, admin.token, "{", http.StatusBadRequest},
+		{"set role invalid ID", "PUT", "/admin/users/not-a-uuid/role", admin.token, map[string]string{"role": "viewer"}, http.StatusBadRequest},
+		{"set own role", "PUT", "/admin/users/" + admin.ID.String() + "/role", admin.token, map[string]string{"role": "viewer"}, http.StatusBadRequest},
+		{"set role anonymously", "PUT", target, "", map[string]string{"role": "admin"}, http.StatusUnauthorized},
+		{"set role as moderator", "PUT", target, moderator.token, map[string]string{"role": "admin"}, http.StatusForbidden},
+		{"set role of unknown user", "PUT", "/admin/users/" + uuid.NewString() + "/role", admin.token, map[string]string{"role": "viewer"}, http.StatusNotFound},
+	})
+
+	got, err := api.db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Role != database.RoleViewer {
+		t.Errorf("role = %s, want %s", got.Role, database.RoleViewer)
+	}
+
+	// Demotions apply to tokens issued before them
+	rec := api.do("POST", "/api/videos", user.token, map[string]string{"title": "x"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("creating a video after demotion = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandlerAdminVideos(t *testing.T) {
+	api := newTestAPI(t)
+	moderator := api.createUser(t, "moderator@example.com", database.RoleModerator)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "reported", database.VisibilityPublic)
+	doomed := api.createVideo(t, owner, "doomed", database.VisibilityPublic)
+	hide := "/admin/videos/" + video.ID.String() + "/hide"
+	unhide := "/admin/videos/" + video.ID.String() + "/unhide"
+	unknown := uuid.NewString()
+
+	runStatusCases(t, api, []statusCase{
+		{"hide anonymously", "POST", hide, "", nil, http.StatusUnauthorized},
+		{"hide as creator", "POST", hide, owner.token, nil, http.StatusForbidden},
+		{"hide invalid ID", "POST", "/admin/videos/not-a-uuid/hide", moderator.token, nil, http.StatusBadRequest},
+		{"hide unknown", "POST", "/admin/videos/" + unknown + "/hide", moderator.token, nil, http.StatusNotFound},
+		{"hide", "POST", hide, moderator.token, nil, http.StatusOK},
+		// Hidden videos look missing to everyone but their owner
+		{"get hidden as another user", "GET", "/api/videos/" + video.ID.String(), moderator.token, nil, http.StatusNotFound},
+		{"get hidden as owner", "GET", "/api/videos/" + video.ID.String(), owner.token, nil, http.StatusOK},
+		{"unhide", "POST", unhide, moderator.token, nil, http.StatusOK},
+		{"get unhidden", "GET", "/api/videos/" + video.ID.String(), "", nil, http.StatusOK},
+		{"unhide unknown", "POST", "/admin/videos/" + unknown + "/unhide", moderator.token, nil, http.StatusNotFound},
+		{"delete anonymously", "DELETE", "/admin/videos/" + doomed.ID.String(), "", nil, http.StatusUnauthorized},
+		{"delete as creator", "DELETE", "/admin/videos/" + doomed.ID.String(), owner.token, nil, http.StatusForbidden},
+		{"delete invalid ID", "DELETE", "/admin/videos/not-a-uuid", moderator.token, nil, http.StatusBadRequest},
+		{"delete unknown", "DELETE", "/admin/videos/" + unknown, moderator.token, nil, http.StatusNotFound},
+		{"delete", "DELETE", "/admin/videos/" + doomed.ID.String(), moderator.token, nil, http.StatusNoContent},
+		{"get deleted", "GET", "/api/videos/" + doomed.ID.String(), owner.token, nil, http.StatusNotFound},
+	})
+}
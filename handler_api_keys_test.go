@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerAPIKeys(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.createUser(t, "user@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	otherKey, err := api.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  other.ID,
+		Name:    "other",
+		KeyHash: auth.HashToken("other"),
+		Scopes:  []database.APIKeyScope{database.ScopeVideosRead},
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	rec := api.do("POST", "/api/api_keys", user.token, map[string]any{"name": "ci", "scopes": []string{"videos:read", "videos:read"}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want %d", rec.Code, rec.Body, http.StatusCreated)
+	}
+	created := decodeBody[struct {
+		database.APIKey
+		Key string `json:"key"`
+	}](t, rec)
+	if !strings.HasPrefix(created.Key, auth.APIKeyPrefix) || len(created.Scopes) != 1 {
+		t.Errorf("created key %q with scopes %v, want a %s key with the scopes deduplicated", created.Key, created.Scopes, auth.APIKeyPrefix)
+	}
+
+	keys := decodeBody[[]database.APIKey](t, api.do("GET", "/api/api_keys", user.token, nil))
+	if len(keys) != 1 || keys[0].ID != created.ID {
+		t.Errorf("listed %+v, want only the caller's key", keys)
+	}
+
+	runStatusCases(t, api, []statusCase{
+		{"create without a name", "POST", "/api/api_keys", user.token, map[string]any{"scopes": []string{"videos:read"}}, http.StatusBadRequest},
+		{"create without scopes", "POST", "/api/api_keys", user.token, map[string]any{"name": "ci"}, http.StatusBadRequest},
+		{"create with an unknown scope", "POST", "/api/api_keys", user.token, map[string]any{"name": "ci", "scopes": []string{"admin"}}, http.StatusBadRequest},
+		{"create malformed body", "POST", "/api/api_keys", user.token, "{", http.StatusBadRequest},
+		{"create anonymously", "POST", "/api/api_keys", "", map[string]any{"name": "ci", "scopes": []string{"videos:read"}}, http.StatusUnauthorized},
+		// A leaked key mustn't be able to mint more
+		{"create with an API key", "POST", "/api/api_keys", created.Key, map[string]any{"name": "ci", "scopes": []string{"videos:read"}}, http.StatusForbidden},
+		{"list", "GET", "/api/api_keys", user.token, nil, http.StatusOK},
+		{"list anonymously", "GET", "/api/api_keys", "", nil, http.StatusUnauthorized},
+		{"list with an API key", "GET", "/api/api_keys", created.Key, nil, http.StatusForbidden},
+		{"revoke invalid ID", "DELETE", "/api/api_keys/not-a-uuid", user.token, nil, http.StatusBadRequest},
+		{"revoke anonymously", "DELETE", "/api/api_keys/" + created.ID.String(), "", nil, http.StatusUnauthorized},
+		{"revoke another user's key", "DELETE", "/api/api_keys/" + otherKey.ID.String(), user.token, nil, http.StatusNotFound},
+		{"revoke unknown", "DELETE", "/api/api_keys/" + uuid.NewString(), user.token, nil, http.StatusNotFound},
+		{"use before revoking", "GET", "/api/feed", created.Key, nil, http.StatusOK},
+		{"revoke", "DELETE", "/api/api_keys/" + created.ID.String(), user.token, nil, http.StatusNoContent},
+		{"use after revoking", "GET", "/api/feed", created.Key, nil, http.StatusUnauthorized},
+		{"unknown key", "GET", "/api/feed", auth.APIKeyPrefix + "unknown", nil, http.StatusUnauthorized},
+	})
+
+	// Revoked keys aren't listed
+	keys = decodeBody[[]database.APIKey](t, api.do("GET", "/api/api_keys", user.token, nil))
+	if len(keys) != 0 {
+		t.Errorf("listed %+v after revoking the only key", keys)
+	}
+}
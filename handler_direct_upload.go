@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// Direct uploads let the browser PUT the video straight to the bucket with a
+// presigned URL. The object lands under the same raw/ staging prefix as a
+// regular upload and is only processed once the client calls the completion
+// endpoint, which accepts each issued key once. Multipart uploads never
+// completed are aborted after staleMultipartUploadAge, and their keys can't
+// be completed after that either.
+
+const (
+	presignTTL              = 15 * time.Minute
+	directUploadPartSize    = 64 << 20
+	maxUploadParts          = 10000
+	staleMultipartUploadAge = 24 * time.Hour
+	multipartCleanupPeriod  = time.Hour
+)
+
+type presignedPart struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+func (cfg *apiConfig) handlerVideoUploadPresign(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Size      int64 `json:"size"`
+		Multipart bool  `json:"multipart"`
+	}
+	type response struct {
+		Key       string            `json:"key"`
+		Method    string            `json:"method,omitempty"`
+		URL       string            `json:"url,omitempty"`
+		Headers   map[string]string `json:"headers,omitempty"`
+		UploadID  string            `json:"upload_id,omitempty"`
+		PartSize  int64             `json:"part_size,omitempty"`
+		Parts     []presignedPart   `json:"parts,omitempty"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	videoID := videoFromContext(r.Context()).ID
+	userID := principalFromContext(r.Context()).UserID
+
+	presigner, ok := cfg.storage.(storage.Presigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this storage backend", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Size <= 0 || params.Size > maxVideoUploadSize {
+		respondWithError(w, http.StatusBadRequest, "Size must be between 1 byte and the maximum upload size", nil)
+		return
+	}
+
+	key, err := newRawUploadKey(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to generate random storage key", err)
+		return
+	}
+
+	const mediaType = "video/mp4"
+	expiresAt := time.Now().UTC().Add(presignTTL)
+	upload := database.CreateDirectUploadParams{
+		Key:       key,
+		VideoID:   videoID,
+		UserID:    userID,
+		Size:      params.Size,
+		ExpiresAt: time.Now().Add(staleMultipartUploadAge),
+	}
+
+	if !params.Multipart && params.Size <= directUploadPartSize {
+		url, err := presigner.PresignPut(r.Context(), key, mediaType, params.Size, presignTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+			return
+		}
+		err = cfg.db.CreateDirectUpload(upload)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record upload", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, response{
+			Key:       key,
+			Method:    http.MethodPut,
+			URL:       url,
+			Headers:   map[string]string{"Content-Type": mediaType, "Content-Length": strconv.FormatInt(params.Size, 10)},
+			ExpiresAt: expiresAt,
+		})
+		return
+	}
+
+	partSize := int64(directUploadPartSize)
+	if params.Size > partSize*maxUploadParts {
+		partSize = (params.Size + maxUploadParts - 1) / maxUploadParts
+	}
+	partCount := (params.Size + partSize - 1) / partSize
+
+	uploadID, err := presigner.CreateMultipartUpload(r.Context(), key, mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start multipart upload", err)
+		return
+	}
+
+	parts := make([]presignedPart, 0, partCount)
+	for i := int32(1); i <= int32(partCount); i++ {
+		url, err := presigner.PresignUploadPart(r.Context(), key, uploadID, i, presignTTL)
+		if err != nil {
+			presigner.AbortMultipartUpload(r.Context(), key, uploadID)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload part", err)
+			return
+		}
+		parts = append(parts, presignedPart{PartNumber: i, URL: url})
+	}
+
+	upload.UploadID = uploadID
+	err = cfg.db.CreateDirectUpload(upload)
+	if err != nil {
+		presigner.AbortMultipartUpload(r.Context(), key, uploadID)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record upload", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Key:       key,
+		UploadID:  uploadID,
+		PartSize:  partSize,
+		Parts:     parts,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (cfg *apiConfig) handlerVideoUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key      string                  `json:"key"`
+		UploadID string                  `json:"upload_id"`
+		Parts    []storage.CompletedPart `json:"parts"`
+	}
+
+	videoID := videoFromContext(r.Context()).ID
+	userID := principalFromContext(r.Context()).UserID
+
+	presigner, ok := cfg.storage.(storage.Presigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this storage backend", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Only keys handed out for this video can be completed, and only once,
+	// so a client can't queue someone else's object or the same one twice.
+	upload, err := cfg.db.CompleteDirectUpload(params.Key, videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Key wasn't issued for this video, has expired or was already completed", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't complete upload", err)
+		return
+	}
+	if params.UploadID != upload.UploadID {
+		cfg.discardDirectUpload(r.Context(), presigner, upload)
+		respondWithError(w, http.StatusBadRequest, "Upload ID doesn't match the one issued for this key", nil)
+		return
+	}
+
+	if upload.UploadID != "" {
+		err = presigner.CompleteMultipartUpload(r.Context(), upload.Key, upload.UploadID, params.Parts)
+		if err != nil {
+			presigner.AbortMultipartUpload(r.Context(), upload.Key, upload.UploadID)
+			respondWithError(w, http.StatusBadRequest, "Couldn't complete multipart upload", err)
+			return
+		}
+	}
+
+	err = cfg.validateStagedVideo(r, upload)
+	if err != nil {
+		cfg.storage.Delete(r.Context(), params.Key)
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, http.StatusBadRequest, "Uploaded object not found", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Uploaded object isn't a valid video", err)
+		return
+	}
+
+	job, err := cfg.enqueueVideoJob(videoID, userID, params.Key, "video/mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to queue video for processing", err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/jobs/"+job.ID.String())
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// discardDirectUpload gets rid of whatever was uploaded for a direct upload
+// that can't be completed anymore.
+func (cfg *apiConfig) discardDirectUpload(ctx context.Context, presigner storage.Presigner, upload database.DirectUpload) {
+	if upload.UploadID != "" {
+		presigner.AbortMultipartUpload(ctx, upload.Key, upload.UploadID)
+	}
+	cfg.storage.Delete(ctx, upload.Key)
+}
+
+// validateStagedVideo checks an object uploaded directly by a client before
+// it is queued: it must exist, have the size given when it was presigned,
+// be stored as video/mp4 and start with an MP4 ftyp box. Single PUTs are
+// signed with their length, but multipart uploads can only be checked here.
+func (cfg *apiConfig) validateStagedVideo(r *http.Request, upload database.DirectUpload) error {
+	key := upload.Key
+	obj, err := cfg.storage.Stat(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	if obj.Size != upload.Size {
+		return fmt.Errorf("object is %d bytes, but %d were presigned", obj.Size, upload.Size)
+	}
+	if obj.ContentType != "video/mp4" {
+		return errors.New("object content type must be video/mp4")
+	}
+
+	body, _, err := cfg.storage.Get(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	header := make([]byte, 8)
+	_, err = io.ReadFull(body, header)
+	if err != nil {
+		return err
+	}
+	if string(header[4:8]) != "ftyp" {
+		return errors.New("object is not an MP4 file")
+	}
+	return nil
+}
+
+// runMultipartCleanup aborts abandoned direct multipart uploads until ctx is
+// done. S3 keeps the parts of an upload, and bills for them, until it is
+// completed or aborted.
+func (cfg *apiConfig) runMultipartCleanup(ctx context.Context, presigner storage.Presigner) {
+	ticker := time.NewTicker(multipartCleanupPeriod)
+	defer ticker.Stop()
+
+	for {
+		aborted, err := presigner.AbortStaleMultipartUploads(ctx, "raw/", time.Now().Add(-staleMultipartUploadAge))
+		if err != nil {
+			log.Printf("Couldn't abort stale multipart uploads: %v", err)
+		}
+		if aborted > 0 {
+			log.Printf("Aborted %d stale multipart uploads", aborted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// openTestS3Store connects to the S3 compatible server at TEST_S3_ENDPOINT,
+// such as MinIO, skipping the test when it isn't set. Credentials come from
+// the usual AWS environment variables.
+func openTestS3Store(t *testing.T) *storage.S3Store {
+	t.Helper()
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT isn't set")
+	}
+	bucket := os.Getenv("TEST_S3_BUCKET")
+	if bucket == "" {
+		t.Fatal("TEST_S3_BUCKET must be set with TEST_S3_ENDPOINT")
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion("us-east-1"))
+	if err != nil {
+		t.Fatalf("LoadDefaultConfig: %v", err)
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	})
+	return storage.NewS3Store(client, bucket, storage.DefaultMultipartConfig())
+}
+
+// TestDirectUploadS3 goes through a direct upload the way a browser does,
+// against a real S3 compatible server: presign, PUT to the returned URLs,
+// complete, and check the staged object.
+func TestDirectUploadS3(t *testing.T) {
+	store := openTestS3Store(t)
+	api := newTestAPI(t)
+	api.cfg.storage = store
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "direct", database.VisibilityPublic)
+	target := "/api/video_upload/" + video.ID.String()
+
+	type presignResponse struct {
+		Key      string            `json:"key"`
+		URL      string            `json:"url"`
+		Headers  map[string]string `json:"headers"`
+		UploadID string            `json:"upload_id"`
+		Parts    []presignedPart   `json:"parts"`
+	}
+	presign := func(t *testing.T, size int, multipart bool) presignResponse {
+		t.Helper()
+		rec := api.do("POST", target+"/presign", owner.token, map[string]any{"size": size, "multipart": multipart})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("presign = %d %s", rec.Code, rec.Body)
+		}
+		presigned := decodeBody[presignResponse](t, rec)
+		t.Cleanup(func() { store.Delete(context.Background(), presigned.Key) })
+		return presigned
+	}
+	put := func(t *testing.T, url string, headers map[string]string, data []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	complete := func(t *testing.T, body map[string]any, presigned presignResponse, data []byte) {
+		t.Helper()
+		rec := api.do("POST", target+"/complete", owner.token, body)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("complete = %d %s", rec.Code, rec.Body)
+		}
+		obj, err := store.Stat(context.Background(), presigned.Key)
+		if err != nil || obj.Size != int64(len(data)) || obj.ContentType != "video/mp4" {
+			t.Errorf("Stat(%q) = %+v, %v, want a %d byte video/mp4", presigned.Key, obj, err, len(data))
+		}
+	}
+	data := append([]byte("\x00\x00\x00\x18ftypmp42"), bytes.Repeat([]byte{0}, 1000)...)
+
+	t.Run("single PUT", func(t *testing.T) {
+		presigned := presign(t, len(data), false)
+
+		// The URL is only good for the presigned length
+		short := put(t, presigned.URL, map[string]string{"Content-Type": "video/mp4", "Content-Length": strconv.Itoa(len(data) - 1)}, data[:len(data)-1])
+		if short.StatusCode < 400 {
+			t.Errorf("PUT of a shorter body = %d, want it rejected", short.StatusCode)
+		}
+
+		resp := put(t, presigned.URL, presigned.Headers, data)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT = %d", resp.StatusCode)
+		}
+		complete(t, map[string]any{"key": presigned.Key}, presigned, data)
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		presigned := presign(t, len(data), true)
+		if len(presigned.Parts) != 1 {
+			t.Fatalf("presigned %d parts, want 1", len(presigned.Parts))
+		}
+
+		resp := put(t, presigned.Parts[0].URL, nil, data)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT part = %d", resp.StatusCode)
+		}
+		parts := []storage.CompletedPart{{PartNumber: 1, ETag: resp.Header.Get("ETag")}}
+		complete(t, map[string]any{"key": presigned.Key, "upload_id": presigned.UploadID, "parts": parts}, presigned, data)
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// fakePresigner hands out made-up URLs for objects in a memory store, which
+// tests write to themselves.
+type fakePresigner struct {
+	*storage.MemoryStore
+	aborted []string
+}
+
+func (p *fakePresigner) PresignGet(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	return "https://bucket.example.com/" + key + "?get", nil
+}
+
+func (p *fakePresigner) PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (string, error) {
+	return "https://bucket.example.com/" + key + "?put", nil
+}
+
+func (p *fakePresigner) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	return "upload-1", nil
+}
+
+func (p *fakePresigner) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("https://bucket.example.com/%s?uploadId=%s&partNumber=%d", key, uploadID, partNumber), nil
+}
+
+func (p *fakePresigner) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []storage.CompletedPart) error {
+	if len(parts) == 0 {
+		return fmt.Errorf("no parts")
+	}
+	return nil
+}
+
+func (p *fakePresigner) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	p.aborted = append(p.aborted, uploadID)
+	return nil
+}
+
+func (p *fakePresigner) AbortStaleMultipartUploads(ctx context.Context, prefix string, startedBefore time.Time) (int, error) {
+	return 0, nil
+}
+
+func TestHandlerVideoUploadPresign(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "direct", database.VisibilityPublic)
+	target := "/api/video_upload/" + video.ID.String() + "/presign"
+
+	runStatusCases(t, api, []statusCase{
+		{"backend without presigning", "POST", target, owner.token, map[string]any{"size": 1000}, http.StatusNotImplemented},
+	})
+
+	api.cfg.storage = &fakePresigner{MemoryStore: api.storage}
+	runStatusCases(t, api, []statusCase{
+		{"single PUT", "POST", target, owner.token, map[string]any{"size": 1000}, http.StatusOK},
+		{"zero size", "POST", target, owner.token, map[string]any{"size": 0}, http.StatusBadRequest},
+		{"too large", "POST", target, owner.token, map[string]any{"size": int64(maxVideoUploadSize) + 1}, http.StatusBadRequest},
+		{"malformed body", "POST", target, owner.token, "{", http.StatusBadRequest},
+		{"invalid ID", "POST", "/api/video_upload/not-a-uuid/presign", owner.token, map[string]any{"size": 1000}, http.StatusBadRequest},
+		{"anonymous", "POST", target, "", map[string]any{"size": 1000}, http.StatusUnauthorized},
+		{"other user", "POST", target, other.token, map[string]any{"size": 1000}, http.StatusForbidden},
+		{"unknown video", "POST", "/api/video_upload/" + uuid.NewString() + "/presign", owner.token, map[string]any{"size": 1000}, http.StatusNotFound},
+	})
+
+	type presignResponse struct {
+		Key      string            `json:"key"`
+		Method   string            `json:"method"`
+		Headers  map[string]string `json:"headers"`
+		UploadID string            `json:"upload_id"`
+		Parts    []presignedPart   `json:"parts"`
+	}
+	single := decodeBody[presignResponse](t, api.do("POST", target, owner.token, map[string]any{"size": 1000}))
+	if single.Method != http.MethodPut || !strings.HasPrefix(single.Key, "raw/"+video.ID.String()+"/") || single.Headers["Content-Length"] != "1000" {
+		t.Errorf("single upload = %+v, want a 1000 byte PUT under the video's raw prefix", single)
+	}
+	multi := decodeBody[presignResponse](t, api.do("POST", target, owner.token, map[string]any{"size": 3*directUploadPartSize - 1}))
+	if multi.UploadID == "" || len(multi.Parts) != 3 {
+		t.Errorf("multipart upload = %+v, want 3 parts", multi)
+	}
+
+	// Issued keys are recorded so they can be completed
+	for _, issued := range []presignResponse{single, multi} {
+		upload, err := api.db.CompleteDirectUpload(issued.Key, video.ID)
+		if err != nil || upload.UploadID != issued.UploadID || upload.UserID != owner.ID {
+			t.Errorf("CompleteDirectUpload(%q) = %+v, %v, want it issued to the owner", issued.Key, upload, err)
+		}
+	}
+}
+
+func TestHandlerVideoUploadComplete(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "direct", database.VisibilityPublic)
+	target := "/api/video_upload/" + video.ID.String() + "/complete"
+	presigner := &fakePresigner{MemoryStore: api.storage}
+	api.cfg.storage = presigner
+
+	// putSized uploads an object the way a client would after presigning
+	// size bytes
+	putSized := func(name, uploadID, contentType, data string, size int64) string {
+		t.Helper()
+		key := "raw/" + video.ID.String() + "/" + name
+		err := api.db.CreateDirectUpload(database.CreateDirectUploadParams{
+			Key:       key,
+			VideoID:   video.ID,
+			UserID:    owner.ID,
+			UploadID:  uploadID,
+			Size:      size,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("CreateDirectUpload: %v", err)
+		}
+		if err := api.storage.Put(context.Background(), key, strings.NewReader(data), contentType); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		return key
+	}
+	put := func(name, uploadID, contentType, data string) string {
+		t.Helper()
+		return putSized(name, uploadID, contentType, data, int64(len(data)))
+	}
+	const mp4Header = "\x00\x00\x00\x18ftypmp42"
+	valid := put("valid.mp4", "", "video/mp4", mp4Header)
+	notMP4 := put("not.mp4", "", "video/mp4", "definitely not a video")
+	wrongType := put("wrong.mp4", "", "text/plain", mp4Header)
+	noParts := put("parts.mp4", "upload-1", "video/mp4", mp4Header)
+	wrongUpload := put("upload.mp4", "", "video/mp4", mp4Header)
+	wrongSize := putSized("size.mp4", "upload-1", "video/mp4", mp4Header, 1000)
+	missing := "raw/" + video.ID.String() + "/missing.mp4"
+	err := api.db.CreateDirectUpload(database.CreateDirectUploadParams{Key: missing, VideoID: video.ID, UserID: owner.ID, Size: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("CreateDirectUpload: %v", err)
+	}
+	unissued := "raw/" + video.ID.String() + "/unissued.mp4"
+	if err := api.storage.Put(context.Background(), unissued, strings.NewReader(mp4Header), "video/mp4"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	runStatusCases(t, api, []statusCase{
+		{"another video's key", "POST", target, owner.token, map[string]string{"key": "raw/" + uuid.NewString() + "/x.mp4"}, http.StatusBadRequest},
+		{"key that wasn't issued", "POST", target, owner.token, map[string]string{"key": unissued}, http.StatusBadRequest},
+		{"missing object", "POST", target, owner.token, map[string]string{"key": missing}, http.StatusBadRequest},
+		{"not an MP4 file", "POST", target, owner.token, map[string]string{"key": notMP4}, http.StatusBadRequest},
+		{"wrong content type", "POST", target, owner.token, map[string]string{"key": wrongType}, http.StatusBadRequest},
+		{"multipart without parts", "POST", target, owner.token, map[string]string{"key": noParts, "upload_id": "upload-1"}, http.StatusBadRequest},
+		{"different size than presigned", "POST", target, owner.token, map[string]any{"key": wrongSize, "upload_id": "upload-1", "parts": []storage.CompletedPart{{PartNumber: 1, ETag: "etag"}}}, http.StatusBadRequest},
+		{"wrong upload ID", "POST", target, owner.token, map[string]string{"key": wrongUpload, "upload_id": "upload-2"}, http.StatusBadRequest},
+		{"malformed body", "POST", target, owner.token, "{", http.StatusBadRequest},
+		{"anonymous", "POST", target, "", map[string]string{"key": valid}, http.StatusUnauthorized},
+		{"other user", "POST", target, other.token, map[string]string{"key": valid}, http.StatusForbidden},
+		{"unknown video", "POST", "/api/video_upload/" + uuid.NewString() + "/complete", owner.token, map[string]string{"key": valid}, http.StatusNotFound},
+		{"valid upload", "POST", target, owner.token, map[string]string{"key": valid}, http.StatusAccepted},
+		{"completed twice", "POST", target, owner.token, map[string]string{"key": valid}, http.StatusBadRequest},
+		{"retried after failing", "POST", target, owner.token, map[string]string{"key": notMP4}, http.StatusBadRequest},
+	})
+
+	// Only the valid upload was queued, once
+	for i, want := range []bool{true, false} {
+		job, err := api.db.ClaimNextJob(time.Now().Add(time.Minute))
+		if err != nil || (job != nil) != want {
+			t.Errorf("ClaimNextJob #%d = %v, %v, want a job: %v", i+1, job, err, want)
+		}
+	}
+
+	// Rejected objects are deleted, and failed multipart uploads aborted
+	for _, key := range []string{notMP4, wrongType, wrongUpload, wrongSize} {
+		if _, err := api.storage.Stat(context.Background(), key); err == nil {
+			t.Errorf("rejected object %s was kept", key)
+		}
+	}
+	if len(presigner.aborted) != 1 {
+		t.Errorf("aborted %v, want the failed multipart upload", presigner.aborted)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type videoPage struct {
+	Videos     []database.Video `json:"videos"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// parseVideoQuery reads the paging, sorting and filtering parameters shared
+// by every video listing:
+//
+//	limit            page size, up to 100
+//	cursor           next_cursor from the previous page
+//	sort             newest, oldest, title or most_viewed
+//	owner            user ID
+//	aspect_ratio     landscape, portrait or other
+//	created_after    RFC 3339 time or YYYY-MM-DD date, inclusive
+//	created_before   RFC 3339 time or YYYY-MM-DD date, exclusive
+func parseVideoQuery(r *http.Request) (database.VideoQuery, error) {
+	values := r.URL.Query()
+	q := database.VideoQuery{
+		Sort:  database.VideoSort(values.Get("sort")),
+		Limit: defaultPageSize,
+	}
+
+	if q.Sort == "" {
+		q.Sort = database.VideoSortNewest
+	}
+	if !q.Sort.Valid() {
+		return q, errors.New("sort must be newest, oldest, title or most_viewed")
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = limit
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := database.ParseVideoCursor(v)
+		if err != nil {
+			return q, err
+		}
+		if cursor.Sort != q.Sort {
+			return q, errors.New("cursor belongs to a different sort order")
+		}
+		q.Cursor = &cursor
+	}
+
+	if v := values.Get("owner"); v != "" {
+		ownerID, err := uuid.Parse(v)
+		if err != nil {
+			return q, errors.New("owner must be a user ID")
+		}
+		q.OwnerID = ownerID
+	}
+
+	if v := values.Get("aspect_ratio"); v != "" {
+		if v != "landscape" && v != "portrait" && v != "other" {
+			return q, errors.New("aspect_ratio must be landscape, portrait or other")
+		}
+		q.AspectRatio = v
+	}
+
+	var err error
+	if q.CreatedAfter, err = parseDateParam(values.Get("created_after")); err != nil {
+		return q, fmt.Errorf("invalid created_after: %w", err)
+	}
+	if q.CreatedBefore, err = parseDateParam(values.Get("created_before")); err != nil {
+		return q, fmt.Errorf("invalid created_before: %w", err)
+	}
+	return q, nil
+}
+
+func parseDateParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+// respondWithVideoPage runs a listing query and responds with the page.
+func (cfg *apiConfig) respondWithVideoPage(w http.ResponseWriter, r *http.Request, q database.VideoQuery) {
+	videos, next, err := cfg.db.QueryVideos(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	for i := range videos {
+		err = cfg.resolveVideoURLs(r.Context(), &videos[i])
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
+			return
+		}
+	}
+	page := videoPage{Videos: videos}
+	if next != nil {
+		page.NextCursor = next.Encode()
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// handlerFeed lists public videos for anyone, signed in or not.
+func (cfg *apiConfig) handlerFeed(w http.ResponseWriter, r *http.Request) {
+	q, err := parseVideoQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	q.Visibility = database.VisibilityPublic
+
+	cfg.respondWithVideoPage(w, r, q)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerFeed(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	public := api.createVideo(t, owner, "public", database.VisibilityPublic)
+	api.createVideo(t, owner, "unlisted", database.VisibilityUnlisted)
+	api.createVideo(t, owner, "private", database.VisibilityPrivate)
+	uploadKey := api.createAPIKey(t, owner, database.ScopeVideosUpload)
+
+	runStatusCases(t, api, []statusCase{
+		{"anonymous", "GET", "/api/feed", "", nil, http.StatusOK},
+		{"by owner", "GET", "/api/feed?owner=" + owner.ID.String() + "&sort=title", owner.token, nil, http.StatusOK},
+		{"invalid sort", "GET", "/api/feed?sort=random", "", nil, http.StatusBadRequest},
+		{"invalid limit", "GET", "/api/feed?limit=0", "", nil, http.StatusBadRequest},
+		{"invalid cursor", "GET", "/api/feed?cursor=nope", "", nil, http.StatusBadRequest},
+		{"invalid owner", "GET", "/api/feed?owner=nope", "", nil, http.StatusBadRequest},
+		{"invalid aspect ratio", "GET", "/api/feed?aspect_ratio=square", "", nil, http.StatusBadRequest},
+		{"invalid date", "GET", "/api/feed?created_after=yesterday", "", nil, http.StatusBadRequest},
+		{"invalid token", "GET", "/api/feed", "not-a-jwt", nil, http.StatusUnauthorized},
+		{"upload-only API key", "GET", "/api/feed", uploadKey, nil, http.StatusForbidden},
+	})
+
+	// The feed only has public videos, even for their owner
+	page := decodeBody[videoPage](t, api.do("GET", "/api/feed", owner.token, nil))
+	if len(page.Videos) != 1 || page.Videos[0].ID != public.ID {
+		t.Errorf("feed lists %v, want only the public video", page.Videos)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobIDString := r.PathValue("jobID")
+	jobID, err := uuid.Parse(jobIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	job, err := cfg.db.GetJob(jobID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Job not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
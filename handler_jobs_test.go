@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerJobGet(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "queued", database.VisibilityPublic)
+	job, err := api.cfg.enqueueVideoJob(video.ID, owner.ID, "raw/input.mp4", "video/mp4")
+	if err != nil {
+		t.Fatalf("enqueueVideoJob: %v", err)
+	}
+	readKey := api.createAPIKey(t, owner, database.ScopeVideosRead)
+	writeKey := api.createAPIKey(t, owner, database.ScopeVideosWrite)
+	target := "/api/jobs/" + job.ID.String()
+
+	runStatusCases(t, api, []statusCase{
+		{"owner", "GET", target, owner.token, nil, http.StatusOK},
+		{"read API key", "GET", target, readKey, nil, http.StatusOK},
+		{"invalid ID", "GET", "/api/jobs/not-a-uuid", owner.token, nil, http.StatusBadRequest},
+		{"anonymous", "GET", target, "", nil, http.StatusUnauthorized},
+		{"write-only API key", "GET", target, writeKey, nil, http.StatusForbidden},
+		// Other users' jobs look missing
+		{"other user", "GET", target, other.token, nil, http.StatusNotFound},
+		{"unknown", "GET", "/api/jobs/" + uuid.NewString(), owner.token, nil, http.StatusNotFound},
+	})
+
+	got := decodeBody[database.Job](t, api.do("GET", target, owner.token, nil))
+	if got.ID != job.ID || got.Status != database.JobStatusPending {
+		t.Errorf("got job %s in status %s, want %s pending", got.ID, got.Status, job.ID)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -9,15 +10,25 @@ import (
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+const (
+	loginChallengeTTL = 5 * time.Minute
+	// maxLoginChallengeAttempts is how many codes may be tried against one
+	// challenge before the password has to be sent again.
+	maxLoginChallengeAttempts = 5
+)
+
+// handlerLogin checks a password. Users with two-factor authentication get a
+// challenge token to send to handlerLoginTOTP with a code, everyone else
+// gets their tokens right away.
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+	type challengeResponse struct {
+		TOTPRequired   bool      `json:"totp_required"`
+		ChallengeToken string    `json:"challenge_token"`
+		ExpiresAt      time.Time `json:"expires_at"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -29,10 +40,14 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := cfg.db.GetUserByEmail(params.Email)
-	if err != nil {
+	if errors.Is(err, database.ErrNotFound) {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
 
 	err = auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
@@ -40,8 +55,102 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.TOTPEnabledAt == nil {
+		cfg.respondWithSession(w, user)
+		return
+	}
+
+	challenge, err := auth.MakeLoginChallenge()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login challenge", err)
+		return
+	}
+	expiresAt := time.Now().UTC().Add(loginChallengeTTL)
+	err = cfg.db.CreateLoginChallenge(database.CreateLoginChallengeParams{
+		TokenHash: auth.HashToken(challenge),
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save login challenge", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, challengeResponse{
+		TOTPRequired:   true,
+		ChallengeToken: challenge,
+		ExpiresAt:      expiresAt,
+	})
+}
+
+// handlerLoginTOTP finishes a login with the challenge token from
+// handlerLogin and a TOTP or recovery code.
+func (cfg *apiConfig) handlerLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	challengeHash := auth.HashToken(params.ChallengeToken)
+	userID, err := cfg.db.AttemptLoginChallenge(challengeHash, maxLoginChallengeAttempts)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login challenge", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login challenge", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(*user, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code", nil)
+		return
+	}
+
+	err = cfg.db.DeleteLoginChallenge(challengeHash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete login challenge", err)
+		return
+	}
+
+	cfg.respondWithSession(w, *user)
+}
+
+// respondWithSession logs the user in with a new access token and a new
+// refresh token family.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, user database.User) {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		string(user.Role),
+		user.TokenVersion,
 		cfg.jwtSecret,
 		time.Hour*24*30,
 	)
@@ -59,7 +168,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerLogin(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.createUser(t, "user@example.com", database.RoleCreator)
+
+	runStatusCases(t, api, []statusCase{
+		{"correct password", "POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "password"}, http.StatusOK},
+		{"wrong password", "POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "wrong"}, http.StatusUnauthorized},
+		{"unknown email", "POST", "/api/login", "", map[string]string{"email": "nobody@example.com", "password": "password"}, http.StatusUnauthorized},
+	})
+
+	session := decodeBody[sessionResponse](t, api.do("POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "password"}))
+	claims, err := auth.ParseJWT(session.Token, testJWTSecret)
+	if err != nil {
+		t.Fatalf("access token doesn't validate: %v", err)
+	}
+	if claims.UserID != user.ID || session.RefreshToken == "" {
+		t.Errorf("logged in as %s with refresh token %q, want %s and a refresh token", claims.UserID, session.RefreshToken, user.ID)
+	}
+}
+
+func TestHandlerLoginTOTP(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.createUser(t, "user@example.com", database.RoleCreator)
+	codes, err := auth.MakeRecoveryCodes(1)
+	if err != nil {
+		t.Fatalf("MakeRecoveryCodes: %v", err)
+	}
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		t.Fatalf("MakeTOTPSecret: %v", err)
+	}
+	if err := api.db.SetPendingTOTPSecret(user.ID, secret); err != nil {
+		t.Fatalf("SetPendingTOTPSecret: %v", err)
+	}
+	if err := api.db.EnableTOTP(user.ID, secret, 0, []string{auth.HashToken(codes[0])}); err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+
+	login := func() string {
+		t.Helper()
+		rec := api.do("POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "password"})
+		challenge := decodeBody[sessionResponse](t, rec)
+		if rec.Code != http.StatusOK || !challenge.TOTPRequired || challenge.Token != "" {
+			t.Fatalf("login with two-factor authentication = %d %s, want a challenge and no tokens", rec.Code, rec.Body)
+		}
+		return challenge.ChallengeToken
+	}
+
+	challenge := login()
+	runStatusCases(t, api, []statusCase{
+		{"malformed body", "POST", "/api/login/totp", "", "{", http.StatusBadRequest},
+		{"unknown challenge", "POST", "/api/login/totp", "", map[string]string{"challenge_token": "nope", "code": codes[0]}, http.StatusUnauthorized},
+		{"wrong code", "POST", "/api/login/totp", "", map[string]string{"challenge_token": challenge, "code": "000000"}, http.StatusUnauthorized},
+		{"recovery code", "POST", "/api/login/totp", "", map[string]string{"challenge_token": challenge, "code": codes[0]}, http.StatusOK},
+		{"challenge used up", "POST", "/api/login/totp", "", map[string]string{"challenge_token": challenge, "code": codes[0]}, http.StatusUnauthorized},
+	})
+
+	// Recovery codes only work once
+	rec := api.do("POST", "/api/login/totp", "", map[string]string{"challenge_token": login(), "code": codes[0]})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("reusing a recovery code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
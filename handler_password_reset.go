@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// Reset emails are limited per address, so nobody can flood an inbox,
+	// and per client IP, so nobody can flood everyone's.
+	resetEmailsPerAddress = 5
+	resetEmailsPerIP      = 20
+	resetEmailWindow      = time.Hour
+	resetEmailTimeout     = time.Minute
+)
+
+// handlerPasswordResetSend emails a password reset link. The account is
+// looked up and the email sent in the background, so neither the response
+// nor how long it takes shows whether the email belongs to an account.
+func (cfg *apiConfig) handlerPasswordResetSend(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if !cfg.resetIPLimiter.allow(clientIP(r)) ||
+		!cfg.resetEmailLimiter.allow(strings.ToLower(strings.TrimSpace(params.Email))) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(resetEmailWindow.Seconds())))
+		respondWithError(w, http.StatusTooManyRequests, "Too many password reset requests, try again later", nil)
+		return
+	}
+
+	go cfg.sendPasswordResetEmailTo(params.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordResetEmailTo sends a reset link if email belongs to an
+// account. It runs after the request is done, so it only logs failures.
+func (cfg *apiConfig) sendPasswordResetEmailTo(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), resetEmailTimeout)
+	defer cancel()
+
+	user, err := cfg.db.GetUserByEmail(email)
+	if errors.Is(err, database.ErrNotFound) {
+		return
+	}
+	if err == nil {
+		err = cfg.sendPasswordResetEmail(ctx, user)
+	}
+	if err != nil {
+		log.Printf("Couldn't send password reset email: %v", err)
+	}
+}
+
+// handlerPasswordReset sets a new password with a token from a reset email.
+// Changing the password revokes the user's access tokens, and their refresh
+// tokens are revoked here, so every existing session ends. Following the
+// link also proves the address is theirs, so it verifies the email too.
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	userID, err := cfg.db.ConsumeEmailToken(auth.HashToken(params.Token), database.EmailTokenResetPassword)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+
+	err = cfg.db.UpdateUserPassword(userID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+	err = cfg.db.RevokeUserRefreshTokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	err = cfg.db.MarkEmailVerified(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerPasswordReset(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.createUser(t, "user@example.com", database.RoleCreator)
+	session := api.login(t, "user@example.com")
+
+	runStatusCases(t, api, []statusCase{
+		{"send malformed body", "POST", "/api/password_reset/send", "", "{", http.StatusBadRequest},
+		// Unknown addresses get the same response
+		{"send to unknown address", "POST", "/api/password_reset/send", "", map[string]string{"email": "nobody@example.com"}, http.StatusAccepted},
+		{"send", "POST", "/api/password_reset/send", "", map[string]string{"email": "user@example.com"}, http.StatusAccepted},
+	})
+
+	token := api.mailer.waitForLinkToken(t, "user@example.com", "reset_password")
+	if token == "" {
+		t.Fatal("no password reset email was sent")
+	}
+
+	runStatusCases(t, api, []statusCase{
+		{"malformed body", "POST", "/api/password_reset", "", "{", http.StatusBadRequest},
+		{"missing password", "POST", "/api/password_reset", "", map[string]string{"token": token}, http.StatusBadRequest},
+		{"unknown token", "POST", "/api/password_reset", "", map[string]string{"token": "nope", "password": "new password"}, http.StatusBadRequest},
+		{"token", "POST", "/api/password_reset", "", map[string]string{"token": token, "password": "new password"}, http.StatusNoContent},
+		{"used token", "POST", "/api/password_reset", "", map[string]string{"token": token, "password": "other password"}, http.StatusBadRequest},
+		// Every existing session ends
+		{"old access token", "GET", "/api/videos", user.token, nil, http.StatusUnauthorized},
+		{"old refresh token", "POST", "/api/refresh", session.RefreshToken, nil, http.StatusUnauthorized},
+		{"new password", "POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "new password"}, http.StatusOK},
+	})
+}
+
+func TestHandlerPasswordResetSendRateLimit(t *testing.T) {
+	api := newTestAPI(t)
+
+	body := map[string]string{"email": "user@example.com"}
+	for i := 0; i < resetEmailsPerAddress; i++ {
+		rec := api.do("POST", "/api/password_reset/send", "", body)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("request %d = %d, want %d", i+1, rec.Code, http.StatusAccepted)
+		}
+	}
+	rec := api.do("POST", "/api/password_reset/send", "", body)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("request past the limit = %d with Retry-After %q, want %d with Retry-After", rec.Code, rec.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+}
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+const refreshTokenTTL = time.Hour * 24 * 60
+
+// handlerRefresh trades a refresh token for a new access token and a new
+// refresh token, revoking the old one. A revoked token being presented again
+// means it leaked, so the whole session is revoked.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,14 +27,55 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	rt, err := cfg.db.GetRefreshToken(refreshToken)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if rt.RevokedAt != nil {
+		cfg.revokeReusedRefreshToken(w, rt)
+		return
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token expired", nil)
+		return
+	}
+
+	// The role may have changed since the last token was issued
+	user, err := cfg.db.GetUser(rt.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	next, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	_, err = cfg.db.RotateRefreshToken(refreshToken, next, time.Now().UTC().Add(refreshTokenTTL))
+	if errors.Is(err, database.ErrRefreshTokenRevoked) {
+		// Another request rotated it first
+		cfg.revokeReusedRefreshToken(w, rt)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		string(user.Role),
+		user.TokenVersion,
 		cfg.jwtSecret,
 		time.Hour,
 	)
@@ -35,10 +85,21 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: next,
 	})
 }
 
+func (cfg *apiConfig) revokeReusedRefreshToken(w http.ResponseWriter, rt database.RefreshToken) {
+	log.Printf("Revoked refresh token presented again for user %s, revoking token family %s", rt.UserID, rt.FamilyID)
+	err := cfg.db.RevokeRefreshTokenFamily(rt.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", nil)
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerRefresh(t *testing.T) {
+	api := newTestAPI(t)
+	api.createUser(t, "user@example.com", database.RoleCreator)
+	session := api.login(t, "user@example.com")
+
+	rec := api.do("POST", "/api/refresh", session.RefreshToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	rotated := decodeBody[sessionResponse](t, rec)
+
+	runStatusCases(t, api, []statusCase{
+		{"no token", "POST", "/api/refresh", "", nil, http.StatusBadRequest},
+		{"unknown token", "POST", "/api/refresh", "not-a-refresh-token", nil, http.StatusUnauthorized},
+		{"access token", "POST", "/api/refresh", rotated.Token, nil, http.StatusUnauthorized},
+		// Presenting the old token again revokes the whole session
+		{"rotated token", "POST", "/api/refresh", session.RefreshToken, nil, http.StatusUnauthorized},
+		{"token from the revoked session", "POST", "/api/refresh", rotated.RefreshToken, nil, http.StatusUnauthorized},
+	})
+}
+
+func TestHandlerRevoke(t *testing.T) {
+	api := newTestAPI(t)
+	api.createUser(t, "user@example.com", database.RoleCreator)
+	session := api.login(t, "user@example.com")
+
+	runStatusCases(t, api, []statusCase{
+		{"no token", "POST", "/api/revoke", "", nil, http.StatusBadRequest},
+		{"refresh token", "POST", "/api/revoke", session.RefreshToken, nil, http.StatusNoContent},
+		{"refresh after revoking", "POST", "/api/refresh", session.RefreshToken, nil, http.StatusUnauthorized},
+	})
+}
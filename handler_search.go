@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerSearch finds public videos, plus the caller's own when signed in,
+// by title and description. q supports "quoted phrases" and prefix* words.
+func (cfg *apiConfig) handlerSearch(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Results    []database.SearchResult `json:"results"`
+		NextCursor string                  `json:"next_cursor,omitempty"`
+	}
+
+	viewerID := principalFromContext(r.Context()).UserID
+
+	values := r.URL.Query()
+	q := database.SearchQuery{
+		Text:     values.Get("q"),
+		ViewerID: viewerID,
+		Limit:    defaultPageSize,
+	}
+	if q.Text == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing search query q", nil)
+		return
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize), err)
+			return
+		}
+		q.Limit = limit
+	}
+	// Ranked results can't be paged by key, so the cursor is an offset
+	if v := values.Get("cursor"); v != "" {
+		offset, err := decodeSearchCursor(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor", err)
+			return
+		}
+		q.Offset = offset
+	}
+
+	results, more, err := cfg.db.SearchVideos(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	for i := range results {
+		err = cfg.resolveVideoURLs(r.Context(), &results[i].Video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
+			return
+		}
+	}
+
+	resp := response{Results: results}
+	if more {
+		resp.NextCursor = encodeSearchCursor(q.Offset + len(results))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func encodeSearchCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeSearchCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(data))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("bad offset %q", data)
+	}
+	return offset, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerSearch(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	public := api.createVideo(t, owner, "Boots in the rain", database.VisibilityPublic)
+	private := api.createVideo(t, owner, "Boots in the snow", database.VisibilityPrivate)
+	uploadKey := api.createAPIKey(t, owner, database.ScopeVideosUpload)
+
+	runStatusCases(t, api, []statusCase{
+		{"anonymous", "GET", "/api/search?q=boots", "", nil, http.StatusOK},
+		{"missing query", "GET", "/api/search", "", nil, http.StatusBadRequest},
+		{"invalid limit", "GET", "/api/search?q=boots&limit=abc", "", nil, http.StatusBadRequest},
+		{"invalid cursor", "GET", "/api/search?q=boots&cursor=!!", "", nil, http.StatusBadRequest},
+		{"invalid token", "GET", "/api/search?q=boots", "not-a-jwt", nil, http.StatusUnauthorized},
+		{"upload-only API key", "GET", "/api/search?q=boots", uploadKey, nil, http.StatusForbidden},
+	})
+
+	type searchResponse struct {
+		Results []database.SearchResult `json:"results"`
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  []database.Video
+	}{
+		{"anonymous", "", []database.Video{public}},
+		{"other user", other.token, []database.Video{public}},
+		{"owner", owner.token, []database.Video{private, public}},
+	}
+	for _, tt := range tests {
+		resp := decodeBody[searchResponse](t, api.do("GET", "/api/search?q=boots", tt.token, nil))
+		found := map[string]bool{}
+		for _, result := range resp.Results {
+			found[result.Video.ID.String()] = true
+		}
+		if len(found) != len(tt.want) {
+			t.Errorf("%s: found %d videos, want %d", tt.name, len(found), len(tt.want))
+		}
+		for _, video := range tt.want {
+			if !found[video.ID.String()] {
+				t.Errorf("%s: %q wasn't found", tt.name, video.Title)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	totpIssuer        = "Tubely"
+	recoveryCodeCount = 10
+)
+
+// checkSecondFactor reports whether code is a current TOTP code or an unused
+// recovery code for the user. Either is used up by the check.
+func (cfg *apiConfig) checkSecondFactor(user database.User, code string) (bool, error) {
+	if user.TOTPEnabledAt == nil {
+		return false, nil
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		return cfg.db.UseTOTPStep(user.ID, step)
+	}
+
+	err := cfg.db.UseRecoveryCode(user.ID, auth.HashToken(auth.NormalizeRecoveryCode(code)))
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handlerTOTPEnroll starts enrolling the caller in two-factor
+// authentication. Re-enrolling, to move to a new authenticator, needs a code
+// from the current one. Nothing changes until handlerTOTPConfirm.
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		Secret string `json:"secret"`
+		// OTPAuthURI is meant to be shown as a QR code.
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	user, ok := cfg.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		params := parameters{}
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+		ok, err := cfg.checkSecondFactor(user, params.Code)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusForbidden, "Invalid two-factor code", nil)
+			return
+		}
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create TOTP secret", err)
+		return
+	}
+	err = cfg.db.SetPendingTOTPSecret(user.ID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPProvisioningURI(secret, totpIssuer, user.Email),
+	})
+}
+
+// handlerTOTPConfirm turns two-factor authentication on once the caller
+// proves their authenticator works, and returns new recovery codes. They are
+// only ever returned here.
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, ok := cfg.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		respondWithError(w, http.StatusBadRequest, "Start enrolling first", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(user.TOTPPendingSecret, strings.ReplaceAll(params.Code, " ", ""), time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid two-factor code", nil)
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(code)
+	}
+
+	err = cfg.db.EnableTOTP(user.ID, user.TOTPPendingSecret, step, hashes)
+	if errors.Is(err, database.ErrNotFound) {
+		// Enrollment was restarted in the meantime
+		respondWithError(w, http.StatusConflict, "Enrollment was restarted, try again", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+// handlerTOTPDisable turns two-factor authentication off. It takes a TOTP or
+// recovery code, so a stolen access token alone can't do it.
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, ok := cfg.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication isn't enabled", nil)
+		return
+	}
+
+	ok, err = cfg.checkSecondFactor(user, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusForbidden, "Invalid two-factor code", nil)
+		return
+	}
+
+	err = cfg.db.DisableTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// currentUser loads the caller, responding when that fails.
+func (cfg *apiConfig) currentUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	user, err := cfg.db.GetUser(principalFromContext(r.Context()).UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists", err)
+		return database.User{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	return *user, true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// totpAt computes the RFC 6238 code an authenticator app shows for secret
+// at t.
+func totpAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret isn't base32: %v", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1_000_000)
+}
+
+func TestHandlerTOTPEnrollment(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.createUser(t, "user@example.com", database.RoleCreator)
+	apiKey := api.createAPIKey(t, user, database.ScopeVideosRead, database.ScopeVideosUpload, database.ScopeVideosWrite)
+
+	runStatusCases(t, api, []statusCase{
+		{"confirm before enrolling", "POST", "/api/totp/confirm", user.token, map[string]string{"code": "123456"}, http.StatusBadRequest},
+		{"disable while off", "POST", "/api/totp/disable", user.token, map[string]string{"code": "123456"}, http.StatusBadRequest},
+		{"enroll anonymously", "POST", "/api/totp/enroll", "", nil, http.StatusUnauthorized},
+		{"enroll with an API key", "POST", "/api/totp/enroll", apiKey, nil, http.StatusForbidden},
+	})
+
+	rec := api.do("POST", "/api/totp/enroll", user.token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enroll = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	enrollment := decodeBody[struct {
+		Secret string `json:"secret"`
+	}](t, rec)
+
+	now := time.Now()
+	runStatusCases(t, api, []statusCase{
+		{"confirm with a wrong code", "POST", "/api/totp/confirm", user.token, map[string]string{"code": "abcdef"}, http.StatusBadRequest},
+		{"confirm malformed body", "POST", "/api/totp/confirm", user.token, "{", http.StatusBadRequest},
+		{"confirm anonymously", "POST", "/api/totp/confirm", "", map[string]string{"code": totpAt(t, enrollment.Secret, now)}, http.StatusUnauthorized},
+		{"confirm", "POST", "/api/totp/confirm", user.token, map[string]string{"code": totpAt(t, enrollment.Secret, now)}, http.StatusOK},
+		// Moving to a new authenticator needs a code from the current one
+		{"re-enroll without a code", "POST", "/api/totp/enroll", user.token, map[string]string{}, http.StatusForbidden},
+		{"disable with a wrong code", "POST", "/api/totp/disable", user.token, map[string]string{"code": "000000"}, http.StatusForbidden},
+		// The code used to confirm can't be used again
+		{"disable with a used code", "POST", "/api/totp/disable", user.token, map[string]string{"code": totpAt(t, enrollment.Secret, now)}, http.StatusForbidden},
+		{"disable", "POST", "/api/totp/disable", user.token, map[string]string{"code": totpAt(t, enrollment.Secret, now.Add(30*time.Second))}, http.StatusNoContent},
+	})
+
+	got, err := api.db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.TOTPEnabledAt != nil {
+		t.Error("two-factor authentication is still on")
+	}
+}
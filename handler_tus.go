@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads following the tus 1.0.0 protocol (https://tus.io) with
+// the creation and termination extensions. Partial uploads are kept in
+// cfg.uploadsRoot until the last byte arrives, then handed to the same
+// processing queue as handlerUploadVideo. Uploads nobody writes to for
+// tusUploadTTL expire and are deleted.
+
+const (
+	tusVersion         = "1.0.0"
+	tusExtensions      = "creation,termination,expiration"
+	tusMaxSize         = maxVideoUploadSize
+	tusUploadTTL       = 24 * time.Hour
+	tusCleanupInterval = time.Hour
+)
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+}
+
+// checkTusVersion rejects requests from clients speaking another version
+// of the protocol.
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and an optional base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("malformed metadata pair %q", pair)
+		}
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("malformed metadata value for %q: %w", parts[0], err)
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
+
+func (cfg *apiConfig) tusUploadPath(id uuid.UUID) string {
+	return filepath.Join(cfg.uploadsRoot, id.String())
+}
+
+// tusUploadExpires is when an upload expires unless it's written to again.
+func tusUploadExpires(upload database.Upload) time.Time {
+	return upload.UpdatedAt.Add(tusUploadTTL)
+}
+
+func setTusExpires(w http.ResponseWriter, expires time.Time) {
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+}
+
+// lockTusUpload marks an upload as being written to. Only one request may
+// change an upload's file at a time; it returns false if another one is.
+func (cfg *apiConfig) lockTusUpload(id uuid.UUID) bool {
+	_, busy := cfg.tusLocks.LoadOrStore(id, struct{}{})
+	return !busy
+}
+
+func (cfg *apiConfig) unlockTusUpload(id uuid.UUID) {
+	cfg.tusLocks.Delete(id)
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(tusMaxSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		respondWithError(w, http.StatusBadRequest, "Upload-Length must be a positive integer", err)
+		return
+	}
+	if length > tusMaxSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds Tus-Max-Size", nil)
+		return
+	}
+
+	metadataHeader := r.Header.Get("Upload-Metadata")
+	metadata, err := parseTusMetadata(metadataHeader)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+
+	videoID, err := uuid.Parse(metadata["video_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a valid video_id", err)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(metadata["filetype"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a valid filetype", err)
+		return
+	}
+	if mediaType != "video/mp4" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Only video/mp4 is supported", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to get video metadata", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You do not have permission to upload a video for this video", nil)
+		return
+	}
+
+	upload, err := cfg.db.CreateUpload(database.CreateUploadParams{
+		VideoID:     videoID,
+		UserID:      userID,
+		Length:      length,
+		ContentType: mediaType,
+		Metadata:    metadataHeader,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	f, err := os.Create(cfg.tusUploadPath(upload.ID))
+	if err != nil {
+		cfg.db.DeleteUpload(upload.ID)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	f.Close()
+
+	w.Header().Set("Location", "/api/tus/"+upload.ID.String())
+	setTusExpires(w, tusUploadExpires(upload))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// getTusUpload loads the upload named in the path and checks the caller owns
+// it. On failure it has already written the response.
+func (cfg *apiConfig) getTusUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return database.Upload{}, false
+	}
+
+	userID := principalFromContext(r.Context()).UserID
+
+	upload, err := cfg.db.GetUpload(uploadID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return database.Upload{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return database.Upload{}, false
+	}
+	if time.Now().After(tusUploadExpires(upload)) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Cache-Control", "no-store")
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	setTusExpires(w, tusUploadExpires(upload))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Upload-Offset must be a non-negative integer", err)
+		return
+	}
+
+	if !cfg.lockTusUpload(upload.ID) {
+		respondWithError(w, http.StatusConflict, "Another request is writing to this upload", nil)
+		return
+	}
+	defer cfg.unlockTusUpload(upload.ID)
+
+	// Reload now that nothing else can move the offset; a request that held
+	// the lock may have finished since the first read.
+	upload, err = cfg.db.GetUpload(upload.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	f, err := os.OpenFile(cfg.tusUploadPath(upload.ID), os.O_RDWR, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	defer f.Close()
+
+	// Drop anything written past the committed offset by an interrupted
+	// request before appending.
+	err = f.Truncate(upload.Offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+	_, err = f.Seek(upload.Offset, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+
+	// Read one byte past the declared length so oversized bodies are caught.
+	// A client sending too much has lost track of the upload, so none of
+	// the chunk is kept.
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(f, io.LimitReader(r.Body, remaining+1))
+	if written > remaining {
+		f.Truncate(upload.Offset)
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Request body exceeds Upload-Length", nil)
+		return
+	}
+
+	// Keep whatever arrived, even if the connection dropped half way, so the
+	// client can resume from there.
+	newOffset := upload.Offset + written
+	err = cfg.db.UpdateUploadOffset(upload.ID, upload.Offset, newOffset)
+	if errors.Is(err, database.ErrUploadOffsetChanged) {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	setTusExpires(w, time.Now().Add(tusUploadTTL))
+
+	if copyErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read upload chunk", copyErr)
+		return
+	}
+
+	if newOffset == upload.Length {
+		job, err := cfg.finishTusUpload(r, upload, f)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Unable to queue video for processing", err)
+			return
+		}
+		w.Header().Set("Tubely-Job-ID", job.ID.String())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves a complete upload into storage and queues it for
+// processing.
+func (cfg *apiConfig) finishTusUpload(r *http.Request, upload database.Upload, f *os.File) (database.Job, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	rawKey, err := newRawUploadKey(upload.VideoID)
+	if err != nil {
+		return database.Job{}, err
+	}
+	err = cfg.storage.Put(r.Context(), rawKey, f, upload.ContentType)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	job, err := cfg.enqueueVideoJob(upload.VideoID, upload.UserID, rawKey, upload.ContentType)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	cfg.removeTusUpload(upload.ID)
+	return job, nil
+}
+
+func (cfg *apiConfig) removeTusUpload(id uuid.UUID) {
+	err := cfg.db.DeleteUpload(id)
+	if err != nil {
+		log.Printf("Couldn't delete upload %s: %v", id, err)
+	}
+	err = os.Remove(cfg.tusUploadPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Couldn't delete upload file %s: %v", id, err)
+	}
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if !cfg.lockTusUpload(upload.ID) {
+		respondWithError(w, http.StatusConflict, "Another request is writing to this upload", nil)
+		return
+	}
+	defer cfg.unlockTusUpload(upload.ID)
+
+	cfg.removeTusUpload(upload.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runTusCleanup deletes expired uploads until ctx is done.
+func (cfg *apiConfig) runTusCleanup(ctx context.Context) {
+	ticker := time.NewTicker(tusCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		cfg.removeExpiredTusUploads()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) removeExpiredTusUploads() {
+	uploads, err := cfg.db.ListStaleUploads(time.Now().Add(-tusUploadTTL))
+	if err != nil {
+		log.Printf("Couldn't list expired uploads: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		// Leave uploads that are being written to for the next pass
+		if !cfg.lockTusUpload(upload.ID) {
+			continue
+		}
+		cfg.removeTusUpload(upload.ID)
+		cfg.unlockTusUpload(upload.ID)
+	}
+}
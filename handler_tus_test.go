@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func tusMetadata(videoID, filetype string) string {
+	return "video_id " + base64.StdEncoding.EncodeToString([]byte(videoID)) +
+		",filetype " + base64.StdEncoding.EncodeToString([]byte(filetype))
+}
+
+// tusRequest sends a tus request with the protocol headers plus headers,
+// given as name and value pairs.
+func (api *testAPI) tusRequest(method, target, token string, body any, headers ...string) *httptest.ResponseRecorder {
+	req := newRequest(method, target, token, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return api.serve(req)
+}
+
+func TestHandlerTusCreate(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "resumable", database.VisibilityPublic)
+	metadata := tusMetadata(video.ID.String(), "video/mp4")
+
+	rec := api.do("OPTIONS", "/api/tus", "", nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Extension") != tusExtensions {
+		t.Errorf("OPTIONS = %d with extensions %q", rec.Code, rec.Header().Get("Tus-Extension"))
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		length   string
+		metadata string
+		want     int
+	}{
+		{"owner", owner.token, "100", metadata, http.StatusCreated},
+		{"zero length", owner.token, "0", metadata, http.StatusBadRequest},
+		{"missing length", owner.token, "", metadata, http.StatusBadRequest},
+		{"too large", owner.token, strconv.Itoa(tusMaxSize + 1), metadata, http.StatusRequestEntityTooLarge},
+		{"malformed metadata", owner.token, "100", "video_id !!!", http.StatusBadRequest},
+		{"missing video ID", owner.token, "100", "filetype " + base64.StdEncoding.EncodeToString([]byte("video/mp4")), http.StatusBadRequest},
+		{"not MP4", owner.token, "100", tusMetadata(video.ID.String(), "video/webm"), http.StatusUnsupportedMediaType},
+		{"anonymous", "", "100", metadata, http.StatusUnauthorized},
+		{"other user's video", other.token, "100", metadata, http.StatusForbidden},
+		{"unknown video", owner.token, "100", tusMetadata(uuid.NewString(), "video/mp4"), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.tusRequest("POST", "/api/tus", tt.token, nil, "Upload-Length", tt.length, "Upload-Metadata", tt.metadata)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	req := newRequest("POST", "/api/tus", owner.token, nil)
+	req.Header.Set("Upload-Length", "100")
+	req.Header.Set("Upload-Metadata", metadata)
+	if rec := api.serve(req); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("without Tus-Resumable = %d, want %d", rec.Code, http.StatusPreconditionFailed)
+	}
+}
+
+func TestHandlerTusUpload(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "resumable", database.VisibilityPublic)
+	data := []byte("\x00\x00\x00\x18ftypmp42 and the rest of the video")
+
+	create := func() string {
+		t.Helper()
+		rec := api.tusRequest("POST", "/api/tus", owner.token, nil,
+			"Upload-Length", strconv.Itoa(len(data)), "Upload-Metadata", tusMetadata(video.ID.String(), "video/mp4"))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create = %d %s", rec.Code, rec.Body)
+		}
+		return rec.Header().Get("Location")
+	}
+	patch := func(location, token string, offset int, chunk []byte) *httptest.ResponseRecorder {
+		return api.tusRequest("PATCH", location, token, chunk,
+			"Content-Type", "application/offset+octet-stream", "Upload-Offset", strconv.Itoa(offset))
+	}
+	location := create()
+	unknown := "/api/tus/" + uuid.NewString()
+
+	tests := []struct {
+		name string
+		rec  *httptest.ResponseRecorder
+		want int
+	}{
+		{"first chunk", patch(location, owner.token, 0, data[:10]), http.StatusNoContent},
+		{"stale offset", patch(location, owner.token, 0, data[10:]), http.StatusConflict},
+		{"negative offset", patch(location, owner.token, -1, data[10:]), http.StatusBadRequest},
+		{"wrong content type", api.tusRequest("PATCH", location, owner.token, data[10:], "Upload-Offset", "10"), http.StatusUnsupportedMediaType},
+		{"patch anonymously", patch(location, "", 10, data[10:]), http.StatusUnauthorized},
+		// Other users' uploads look missing
+		{"patch as other user", patch(location, other.token, 10, data[10:]), http.StatusNotFound},
+		{"patch unknown", patch(unknown, owner.token, 0, data), http.StatusNotFound},
+		{"head", api.tusRequest("HEAD", location, owner.token, nil), http.StatusOK},
+		{"head as other user", api.tusRequest("HEAD", location, other.token, nil), http.StatusNotFound},
+		{"head anonymously", api.tusRequest("HEAD", location, "", nil), http.StatusUnauthorized},
+		{"past the length", patch(location, owner.token, 10, append(data[10:], 'x')), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if tt.rec.Code != tt.want {
+			t.Errorf("%s = %d, want %d: %s", tt.name, tt.rec.Code, tt.want, tt.rec.Body)
+		}
+	}
+
+	// None of the oversized chunk was kept
+	rec := api.tusRequest("HEAD", location, owner.token, nil)
+	if rec.Header().Get("Upload-Offset") != "10" {
+		t.Errorf("offset after an oversized chunk = %q, want 10", rec.Header().Get("Upload-Offset"))
+	}
+	rec = patch(location, owner.token, 10, data[10:])
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tubely-Job-ID") == "" {
+		t.Fatalf("last chunk = %d with job %q, want %d and a job", rec.Code, rec.Header().Get("Tubely-Job-ID"), http.StatusNoContent)
+	}
+	// Finished uploads are gone
+	if rec := api.tusRequest("HEAD", location, owner.token, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("head after finishing = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	location = create()
+	rec = patch(location, owner.token, 0, data)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tubely-Job-ID") == "" {
+		t.Fatalf("whole upload = %d with job %q, want %d and a job", rec.Code, rec.Header().Get("Tubely-Job-ID"), http.StatusNoContent)
+	}
+	jobID, err := uuid.Parse(rec.Header().Get("Tubely-Job-ID"))
+	if err != nil {
+		t.Fatalf("job ID: %v", err)
+	}
+	if _, err := api.db.GetJob(jobID); err != nil {
+		t.Errorf("GetJob: %v", err)
+	}
+
+	location = create()
+	deletes := []struct {
+		name  string
+		url   string
+		token string
+		want  int
+	}{
+		{"anonymously", location, "", http.StatusUnauthorized},
+		{"as other user", location, other.token, http.StatusNotFound},
+		{"unknown", unknown, owner.token, http.StatusNotFound},
+		{"owner", location, owner.token, http.StatusNoContent},
+		{"again", location, owner.token, http.StatusNotFound},
+	}
+	for _, tt := range deletes {
+		if rec := api.tusRequest("DELETE", tt.url, tt.token, nil); rec.Code != tt.want {
+			t.Errorf("delete %s = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
@@ -2,41 +2,25 @@ package main
 
 // streak
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"mime"
 	"net/http"
-	"os"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnail"
 )
 
-func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
-	}
+const maxThumbnailUploadSize = 20 << 20
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
+	metadata := videoFromContext(r.Context())
+	videoID := metadata.ID
+	userID := principalFromContext(r.Context()).UserID
 
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
 
 	const maxMemory = 10 << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxThumbnailUploadSize)
 	r.ParseMultipartForm(maxMemory)
 
 	multipartfile, multipartheader, err := r.FormFile("thumbnail")
@@ -63,49 +47,22 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	exts, _ := mime.ExtensionsByType(mediaType)
-	ext := ""
-	if len(exts) > 0 {
-		ext = exts[0]
-	} else {
-		ext = ".png" // fallback
-	}
-
-	metadata, err := cfg.db.GetVideo(videoID)
+	thumb, _, err := cfg.storeThumbnail(r.Context(), multipartfile)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to get video metadata", err)
+		switch {
+		case errors.Is(err, thumbnail.ErrUnsupported):
+			respondWithError(w, http.StatusBadRequest, "Thumbnail isn't a valid JPEG or PNG image", err)
+		case errors.Is(err, thumbnail.ErrTooSmall):
+			respondWithError(w, http.StatusBadRequest, "Thumbnail is too small", err)
+		case errors.Is(err, thumbnail.ErrTooLarge):
+			respondWithError(w, http.StatusBadRequest, "Thumbnail dimensions are too large", err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Unable to store thumbnail", err)
+		}
 		return
 	}
 
-	if metadata.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You do not have permission to upload a thumbnail for this video", nil)
-		return
-	}
-
-	randomBytes := make([]byte, 32)
-	_, err = rand.Read(randomBytes)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to generate random filename", err)
-		return
-	}
-	randomName := base64.RawURLEncoding.EncodeToString(randomBytes)
-
-	filepath := "assets/" + randomName + ext
-	systemfile, err := os.Create(filepath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create system file for thumbnail", err)
-	}
-	defer systemfile.Close()
-
-	_, err = io.Copy(systemfile, multipartfile)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to write thumbnail to file", err)
-		return
-	}
-
-	fileURL := "http://localhost:8091/assets/" + randomName + ext
-
-	metadata.ThumbnailURL = &fileURL
+	metadata.Thumbnail = &thumb
 
 	//print the thumbnail
 	fmt.Printf("Thumbnail uploaded for video %s by user %s, URL: is too long", videoID, userID)
@@ -116,5 +73,11 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	err = cfg.resolveVideoURLs(r.Context(), &metadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, metadata)
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnail"
+	"github.com/google/uuid"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandlerUploadThumbnail(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "needs a thumbnail", database.VisibilityPublic)
+	target := "/api/thumbnail_upload/" + video.ID.String()
+	image := testPNG(t, 64, 36)
+
+	tests := []struct {
+		name        string
+		target      string
+		token       string
+		field       string
+		contentType string
+		data        []byte
+		want        int
+	}{
+		{"wrong field", target, owner.token, "file", "image/png", image, http.StatusBadRequest},
+		{"missing media type", target, owner.token, "thumbnail", "", image, http.StatusBadRequest},
+		{"GIF", target, owner.token, "thumbnail", "image/gif", image, http.StatusBadRequest},
+		{"not an image", target, owner.token, "thumbnail", "image/png", []byte("not a png"), http.StatusBadRequest},
+		{"too small", target, owner.token, "thumbnail", "image/png", testPNG(t, 8, 8), http.StatusBadRequest},
+		{"invalid ID", "/api/thumbnail_upload/not-a-uuid", owner.token, "thumbnail", "image/png", image, http.StatusBadRequest},
+		{"anonymous", target, "", "thumbnail", "image/png", image, http.StatusUnauthorized},
+		{"other user", target, other.token, "thumbnail", "image/png", image, http.StatusForbidden},
+		{"unknown video", "/api/thumbnail_upload/" + uuid.NewString(), owner.token, "thumbnail", "image/png", image, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := api.upload(t, tt.target, tt.token, tt.field, tt.contentType, tt.data)
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	// ffmpeg without libwebp only gets JPEG renditions
+	t.Run("owner, JPEG only", func(t *testing.T) {
+		api.cfg.webpEnabled = false
+		got := uploadThumbnail(t, api, video, owner, image)
+		// Larger sizes would only be upscaled
+		if len(got.Sizes) != 1 || got.Sizes[0].Width != 64 {
+			t.Errorf("a 64px wide image got renditions %+v, want one 64px wide", got.Sizes)
+		}
+		for _, size := range got.Sizes {
+			if size.JPEGKey == "" || size.WebPKey != "" {
+				t.Errorf("%s rendition has JPEG %q and WebP %q, want only JPEG", size.Name, size.JPEGKey, size.WebPKey)
+			}
+		}
+	})
+
+	t.Run("owner, with WebP", func(t *testing.T) {
+		if !thumbnail.WebPSupported(context.Background()) {
+			t.Skip("ffmpeg with libwebp isn't installed")
+		}
+		api.cfg.webpEnabled = true
+		got := uploadThumbnail(t, api, video, owner, image)
+		for _, size := range got.Sizes {
+			if size.JPEGKey == "" || size.WebPKey == "" {
+				t.Errorf("%s rendition has JPEG %q and WebP %q, want both", size.Name, size.JPEGKey, size.WebPKey)
+			}
+		}
+	})
+}
+
+// uploadThumbnail uploads data as the thumbnail of video and returns the
+// stored thumbnail.
+func uploadThumbnail(t *testing.T, api *testAPI, video database.Video, owner testUser, data []byte) database.Thumbnail {
+	t.Helper()
+	resp := api.upload(t, "/api/thumbnail_upload/"+video.ID.String(), owner.token, "thumbnail", "image/png", data)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	got, err := api.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if got.Thumbnail == nil || len(got.Thumbnail.Sizes) == 0 {
+		t.Fatalf("thumbnail = %+v, want renditions", got.Thumbnail)
+	}
+	return *got.Thumbnail
+}
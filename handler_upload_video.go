@@ -2,51 +2,37 @@ package main
 
 import (
 	"bytes"
-	"crypto/rand"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"mime"
 	"net/http"
-	"os"
 	"os/exec"
 	"strings"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
 )
 
+// maxVideoUploadSize is the largest video accepted by resumable and direct uploads.
+const maxVideoUploadSize = 10 << 30
+
 type ffprobeStreams struct {
 	Streams []struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
+		CodecType string `json:"codec_type"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
 	} `json:"streams"`
 }
 
+type videoInfo struct {
+	Width    int
+	Height   int
+	HasAudio bool
+}
+
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
 	fmt.Println("Request URL:", r.URL.Path)
-	fmt.Println("videoIDString:", videoIDString)
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
-	}
-
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	metadata := videoFromContext(r.Context())
+	videoID := metadata.ID
+	userID := principalFromContext(r.Context()).UserID
 
 	fmt.Println("uploading video", videoID, "by user", userID)
 
@@ -76,114 +62,72 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	metadata, err := cfg.db.GetVideo(videoID)
+	// Stash the raw upload so a worker can pick it up after we respond
+	rawKey, err := newRawUploadKey(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to get video metadata", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to generate random storage key", err)
 		return
 	}
 
-	fmt.Println("metadata.UserID:", metadata.UserID)
-	fmt.Println("userID:", userID)
-
-	if metadata.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You do not have permission to upload a video for this video", nil)
-		return
-	}
-
-	videoFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	err = cfg.storage.Put(r.Context(), rawKey, multipartfile, mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create temporary file", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to store uploaded video", err)
 		return
 	}
-	defer os.Remove(videoFile.Name())
-	defer videoFile.Close()
 
-	_, err = io.Copy(videoFile, multipartfile)
+	job, err := cfg.enqueueVideoJob(videoID, userID, rawKey, mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to copy video file", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to queue video for processing", err)
 		return
 	}
 
-	// Process the video for fast start
-	videoFilePath, err := processVideoForFastStart(videoFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to process video for fast start", err)
-		return
-	}
-	videoFile, err = os.Open(videoFilePath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to open processed video file", err)
-		return
-	}
-	defer os.Remove(videoFile.Name())
-	defer videoFile.Close()
+	w.Header().Set("Location", "/api/jobs/"+job.ID.String())
+	respondWithJSON(w, http.StatusAccepted, job)
+}
 
-	_, err = videoFile.Seek(0, io.SeekStart)
+func probeVideoStreams(ctx context.Context, filePath string) (ffprobeStreams, error) {
+	arguments := "-v error -print_format json -show_streams " + filePath
+	cmd := exec.CommandContext(ctx, "ffprobe", strings.Split(arguments, " ")...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	err := cmd.Run()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to seek in video file", err)
-		return
+		return ffprobeStreams{}, err
 	}
 
-	// Determine the aspect ratio of the video
-	aspectRatio, err := getVideoAspectRatio(videoFile.Name())
+	var probe ffprobeStreams
+	err = json.Unmarshal(output.Bytes(), &probe)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to determine video aspect ratio", err)
-		return
-	}
-	var prefix string
-	switch aspectRatio {
-	case "16:9":
-		prefix = "landscape"
-	case "9:16":
-		prefix = "portrait"
-	default:
-		prefix = "other"
+		return ffprobeStreams{}, err
 	}
+	return probe, nil
+}
 
-	// Generate a random 32-byte hex string for the S3 key
-	randomBytes := make([]byte, 32)
-	_, err = rand.Read(randomBytes)
+// getVideoInfo returns the size of the first video stream and whether the
+// file has any audio.
+func getVideoInfo(ctx context.Context, filePath string) (videoInfo, error) {
+	probe, err := probeVideoStreams(ctx, filePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to generate random S3 key", err)
-		return
+		return videoInfo{}, err
 	}
-	randomHex := hex.EncodeToString(randomBytes)
-	s3Key := fmt.Sprintf("%s/%s.mp4", prefix, randomHex)
-
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(s3Key),
-		Body:        videoFile,
-		ContentType: &mediaType,
-	})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to upload video to S3", err)
-		return
+	info := videoInfo{}
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			info.HasAudio = true
+		}
+		if info.Width == 0 && stream.Width > 0 && stream.Height > 0 {
+			info.Width = stream.Width
+			info.Height = stream.Height
+		}
 	}
-
-	metadata.VideoURL = aws.String(fmt.Sprintf("%s/%s", cfg.CFD, s3Key))
-	fmt.Print("Video URL: ", *metadata.VideoURL)
-	err = cfg.db.UpdateVideo(metadata)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to update video metadata", err)
-		return
+	if info.Width == 0 {
+		return videoInfo{}, fmt.Errorf("no video stream with width and height found")
 	}
-
-	respondWithJSON(w, http.StatusOK, map[string]string{"url": *metadata.VideoURL})
+	return info, nil
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	arguments := "-v error -print_format json -show_streams " + filePath
-	cmd := exec.Command("ffprobe", strings.Split(arguments, " ")...)
-	var output bytes.Buffer
-	cmd.Stdout = &output
-	err := cmd.Run()
-	if err != nil {
-		return "", err
-	}
-
-	var probe ffprobeStreams
-	err = json.Unmarshal(output.Bytes(), &probe)
+func getVideoAspectRatio(ctx context.Context, filePath string) (string, error) {
+	probe, err := probeVideoStreams(ctx, filePath)
 	if err != nil {
 		return "", err
 	}
@@ -218,9 +162,10 @@ func abs(x float64) float64 {
 	return x
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+func processVideoForFastStart(ctx context.Context, filePath string) (string, error) {
 	newPath := filePath + ".processing"
-	cmd := exec.Command(
+	cmd := exec.CommandContext(
+		ctx,
 		"ffmpeg",
 		"-i", filePath,
 		"-movflags", "faststart",
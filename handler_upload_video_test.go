@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerUploadVideo(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	viewer := api.createUser(t, "viewer@example.com", database.RoleViewer)
+	video := api.createVideo(t, owner, "needs a file", database.VisibilityPublic)
+	target := "/api/video_upload/" + video.ID.String()
+	data := []byte("\x00\x00\x00\x18ftypmp42")
+
+	tests := []struct {
+		name        string
+		target      string
+		token       string
+		field       string
+		contentType string
+		want        int
+	}{
+		{"wrong field", target, owner.token, "file", "video/mp4", http.StatusBadRequest},
+		{"missing media type", target, owner.token, "video", "", http.StatusBadRequest},
+		{"not MP4", target, owner.token, "video", "video/webm", http.StatusUnsupportedMediaType},
+		{"invalid ID", "/api/video_upload/not-a-uuid", owner.token, "video", "video/mp4", http.StatusBadRequest},
+		{"anonymous", target, "", "video", "video/mp4", http.StatusUnauthorized},
+		{"viewer", target, viewer.token, "video", "video/mp4", http.StatusForbidden},
+		{"other user", target, other.token, "video", "video/mp4", http.StatusForbidden},
+		{"unknown video", "/api/video_upload/" + uuid.NewString(), owner.token, "video", "video/mp4", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := api.upload(t, tt.target, tt.token, tt.field, tt.contentType, data)
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	// The upload is stashed and queued; workers aren't running in tests
+	resp := api.upload(t, target, owner.token, "video", "video/mp4", data)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	var job database.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatalf("couldn't decode job: %v", err)
+	}
+	if resp.Header.Get("Location") != "/api/jobs/"+job.ID.String() {
+		t.Errorf("Location = %q, want the job", resp.Header.Get("Location"))
+	}
+	job, err := api.db.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if job.VideoID != video.ID || !strings.HasPrefix(job.InputKey, "raw/"+video.ID.String()+"/") {
+		t.Errorf("queued %+v, want a job for the video's raw upload", job)
+	}
+	if _, err := api.storage.Stat(context.Background(), job.InputKey); err != nil {
+		t.Errorf("raw upload wasn't stored: %v", err)
+	}
+}
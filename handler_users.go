@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
+	"net/mail"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -26,6 +29,11 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
 		return
 	}
+	addr, err := mail.ParseAddress(params.Email)
+	if err != nil || addr.Address != params.Email {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
 
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -42,5 +50,21 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// The account is usable without the email, it can be sent again later,
+	// so a slow mail server doesn't hold up signing up
+	go cfg.sendSignupVerificationEmail(*user)
+
 	respondWithJSON(w, http.StatusCreated, user)
 }
+
+// sendSignupVerificationEmail sends a new user their verification link. It
+// runs after the request is done, so it only logs failures.
+func (cfg *apiConfig) sendSignupVerificationEmail(user database.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), resetEmailTimeout)
+	defer cancel()
+
+	err := cfg.sendVerificationEmail(ctx, user)
+	if err != nil {
+		log.Printf("Couldn't send verification email: %v", err)
+	}
+}
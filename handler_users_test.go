@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerUsersCreate(t *testing.T) {
+	api := newTestAPI(t)
+
+	runStatusCases(t, api, []statusCase{
+		{"new user", "POST", "/api/users", "", map[string]string{"email": "new@example.com", "password": "hunter22"}, http.StatusCreated},
+		{"missing password", "POST", "/api/users", "", map[string]string{"email": "new@example.com"}, http.StatusBadRequest},
+		{"missing email", "POST", "/api/users", "", map[string]string{"password": "hunter22"}, http.StatusBadRequest},
+		{"invalid email", "POST", "/api/users", "", map[string]string{"email": "Boots <boots@example.com>", "password": "hunter22"}, http.StatusBadRequest},
+	})
+
+	user, err := api.db.GetUserByEmail("new@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if user.Role != database.RoleCreator || user.EmailVerifiedAt != nil {
+		t.Errorf("new user is a %s with verified email %v, want an unverified creator", user.Role, user.EmailVerifiedAt)
+	}
+	if api.mailer.waitForLinkToken(t, "new@example.com", "verify_email") == "" {
+		t.Error("no verification email was sent")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerVerifyEmail(t *testing.T) {
+	api := newTestAPI(t)
+	verified := api.createUser(t, "verified@example.com", database.RoleCreator)
+	created, err := api.db.CreateUser(database.CreateUserParams{Email: "new@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	user := api.userWithToken(t, created.ID)
+
+	runStatusCases(t, api, []statusCase{
+		{"send", "POST", "/api/verify_email/send", user.token, nil, http.StatusAccepted},
+		{"send when verified", "POST", "/api/verify_email/send", verified.token, nil, http.StatusBadRequest},
+		{"send anonymously", "POST", "/api/verify_email/send", "", nil, http.StatusUnauthorized},
+	})
+
+	token := api.mailer.linkToken("new@example.com", "verify_email")
+	if token == "" {
+		t.Fatal("no verification email was sent")
+	}
+	runStatusCases(t, api, []statusCase{
+		{"malformed body", "POST", "/api/verify_email", "", "{", http.StatusBadRequest},
+		{"unknown token", "POST", "/api/verify_email", "", map[string]string{"token": "nope"}, http.StatusBadRequest},
+		{"token", "POST", "/api/verify_email", "", map[string]string{"token": token}, http.StatusNoContent},
+		{"used token", "POST", "/api/verify_email", "", map[string]string{"token": token}, http.StatusBadRequest},
+	})
+
+	got, err := api.db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.EmailVerifiedAt == nil {
+		t.Error("email isn't verified")
+	}
+}
@@ -1,39 +1,46 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
+// A viewer, or an anonymous client IP, adds at most one view to a video per
+// viewWindow, so reloading a page doesn't inflate its count.
+const viewWindow = 30 * time.Minute
+
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := principalFromContext(r.Context()).UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
+	if params.Visibility != "" && !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+	if !cfg.visibilityAllowed(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, errCDNVisibility, nil)
+		return
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
@@ -44,44 +51,88 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
-func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string              `json:"title"`
+		Description *string              `json:"description"`
+		Visibility  *database.Visibility `json:"visibility"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	video := videoFromContext(r.Context())
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	if params.Visibility != nil && !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+	if params.Visibility != nil && !cfg.visibilityAllowed(*params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, errCDNVisibility, nil)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	if params.Title != nil {
+		video.Title = *params.Title
+	}
+	if params.Description != nil {
+		video.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		video.Visibility = *params.Visibility
+	}
+	err = cfg.db.UpdateVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
+	video := videoFromContext(r.Context())
+
+	err := cfg.db.DeleteVideo(video.ID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.deleteVideoFiles(context.WithoutCancel(r.Context()), video)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// deleteVideoFiles deletes everything stored for a deleted video: the
+// processed file, every thumbnail rendition and all versions of its
+// streams. Failures are logged, since the video itself is already gone.
+func (cfg *apiConfig) deleteVideoFiles(ctx context.Context, video database.Video) {
+	keys := []string{}
+	if video.VideoKey != nil {
+		keys = append(keys, *video.VideoKey)
+	}
+	if video.Thumbnail != nil {
+		// Thumbnails from before renditions only have SrcKey
+		keys = append(keys, video.Thumbnail.SrcKey)
+		for _, size := range video.Thumbnail.Sizes {
+			keys = append(keys, size.JPEGKey, size.WebPKey)
+		}
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool { return key == "" })
+	cfg.deleteKeys(ctx, keys)
+	cfg.deleteStreams(ctx, "streams/"+video.ID.String()+"/", "")
+}
+
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -90,37 +141,80 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	viewerID := principalFromContext(r.Context()).UserID
+
 	video, err := cfg.db.GetVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
 
-	if video.ID == uuid.Nil {
+	// Private videos look missing to everyone but their owner
+	if !canViewVideo(video, viewerID) {
 		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
-}
+	if viewerID != video.UserID && cfg.countView(r, video.ID, viewerID) {
+		err = cfg.db.IncrementVideoViews(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count view", err)
+			return
+		}
+		video.ViewCount++
+	}
 
-func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	err = cfg.resolveVideoURLs(r.Context(), &video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video URLs", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	err = cfg.setDeliveryCookies(w, video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign delivery cookies", err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// countView reports whether fetching a video is a view worth counting: not
+// a HEAD or a browser prefetch, and the first from this viewer, or from this
+// IP for anonymous viewers, in viewWindow.
+func (cfg *apiConfig) countView(r *http.Request, videoID, viewerID uuid.UUID) bool {
+	if r.Method == http.MethodHead || isPrefetch(r) {
+		return false
+	}
+	viewer := "ip:" + clientIP(r)
+	if viewerID != uuid.Nil {
+		viewer = "user:" + viewerID.String()
+	}
+	return cfg.viewLimiter.allow(videoID.String() + " " + viewer)
+}
+
+// isPrefetch reports whether the browser is fetching ahead of the user
+// actually opening the page.
+func isPrefetch(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Sec-Purpose"), "prefetch") ||
+		r.Header.Get("Purpose") == "prefetch" ||
+		r.Header.Get("X-Moz") == "prefetch"
+}
+
+func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := principalFromContext(r.Context()).UserID
+
+	q, err := parseVideoQuery(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
+	// Only ever list the caller's own videos here, the feed is for others'
+	q.OwnerID = userID
+	q.IncludeHidden = true
 
-	respondWithJSON(w, http.StatusOK, videos)
+	cfg.respondWithVideoPage(w, r, q)
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestHandlerVideoMetaCreate(t *testing.T) {
+	api := newTestAPI(t)
+	creator := api.createUser(t, "creator@example.com", database.RoleCreator)
+	viewer := api.createUser(t, "viewer@example.com", database.RoleViewer)
+	unverified, err := api.db.CreateUser(database.CreateUserParams{Email: "new@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	unverifiedToken := api.userWithToken(t, unverified.ID).token
+	readKey := api.createAPIKey(t, creator, database.ScopeVideosRead)
+	uploadKey := api.createAPIKey(t, creator, database.ScopeVideosUpload)
+
+	body := map[string]string{"title": "Boots", "description": "A video about boots"}
+	runStatusCases(t, api, []statusCase{
+		{"creator", "POST", "/api/videos", creator.token, body, http.StatusCreated},
+		{"upload API key", "POST", "/api/videos", uploadKey, body, http.StatusCreated},
+		{"invalid visibility", "POST", "/api/videos", creator.token, map[string]string{"title": "Boots", "visibility": "secret"}, http.StatusBadRequest},
+		{"anonymous", "POST", "/api/videos", "", body, http.StatusUnauthorized},
+		{"invalid token", "POST", "/api/videos", "not-a-jwt", body, http.StatusUnauthorized},
+		{"viewer", "POST", "/api/videos", viewer.token, body, http.StatusForbidden},
+		{"unverified email", "POST", "/api/videos", unverifiedToken, body, http.StatusForbidden},
+		{"read-only API key", "POST", "/api/videos", readKey, body, http.StatusForbidden},
+	})
+
+	rec := api.do("POST", "/api/videos", creator.token, map[string]string{"title": "Owned", "visibility": "public"})
+	video := decodeBody[database.Video](t, rec)
+	if video.UserID != creator.ID || video.Title != "Owned" || video.Visibility != database.VisibilityPublic {
+		t.Errorf("created %+v, want a public video titled Owned owned by the caller", video)
+	}
+}
+
+func TestHandlerVideoGet(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	public := api.createVideo(t, owner, "public", database.VisibilityPublic)
+	private := api.createVideo(t, owner, "private", database.VisibilityPrivate)
+	unlisted := api.createVideo(t, owner, "unlisted", database.VisibilityUnlisted)
+
+	runStatusCases(t, api, []statusCase{
+		{"public, anonymous", "GET", "/api/videos/" + public.ID.String(), "", nil, http.StatusOK},
+		{"unlisted, other user", "GET", "/api/videos/" + unlisted.ID.String(), other.token, nil, http.StatusOK},
+		{"private, owner", "GET", "/api/videos/" + private.ID.String(), owner.token, nil, http.StatusOK},
+		{"invalid ID", "GET", "/api/videos/not-a-uuid", "", nil, http.StatusBadRequest},
+		{"invalid token", "GET", "/api/videos/" + public.ID.String(), "not-a-jwt", nil, http.StatusUnauthorized},
+		{"private, other user", "GET", "/api/videos/" + private.ID.String(), other.token, nil, http.StatusNotFound},
+		{"private, anonymous", "GET", "/api/videos/" + private.ID.String(), "", nil, http.StatusNotFound},
+		{"unknown", "GET", "/api/videos/" + uuid.NewString(), "", nil, http.StatusNotFound},
+	})
+
+	// Views by others are counted, the owner's aren't
+	got := decodeBody[database.Video](t, api.do("GET", "/api/videos/"+public.ID.String(), owner.token, nil))
+	if got.ViewCount != 1 {
+		t.Errorf("view count = %d, want 1", got.ViewCount)
+	}
+}
+
+func TestVideoViewCounting(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleViewer)
+	video := api.createVideo(t, owner, "public", database.VisibilityPublic)
+	target := "/api/videos/" + video.ID.String()
+
+	fetch := func(method, credential, remoteAddr string, header map[string]string) {
+		t.Helper()
+		req := newRequest(method, target, credential, nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		if rec := api.serve(req); rec.Code != http.StatusOK {
+			t.Fatalf("%s %s = %d %s", method, target, rec.Code, rec.Body)
+		}
+	}
+	cases := []struct {
+		name       string
+		method     string
+		credential string
+		remoteAddr string
+		header     map[string]string
+		wantViews  int64
+	}{
+		{"anonymous", "GET", "", "192.0.2.1:1234", nil, 1},
+		{"same IP again", "GET", "", "192.0.2.1:5678", nil, 1},
+		{"another IP", "GET", "", "192.0.2.2:1234", nil, 2},
+		{"signed in, same IP", "GET", other.token, "192.0.2.1:1234", nil, 3},
+		{"signed in, another IP", "GET", other.token, "192.0.2.3:1234", nil, 3},
+		{"owner", "GET", owner.token, "192.0.2.4:1234", nil, 3},
+		{"HEAD", "HEAD", "", "192.0.2.5:1234", nil, 3},
+		{"Sec-Purpose prefetch", "GET", "", "192.0.2.6:1234", map[string]string{"Sec-Purpose": "prefetch;prerender"}, 3},
+		{"Purpose prefetch", "GET", "", "192.0.2.6:1234", map[string]string{"Purpose": "prefetch"}, 3},
+		{"X-Moz prefetch", "GET", "", "192.0.2.6:1234", map[string]string{"X-Moz": "prefetch"}, 3},
+		{"after prefetching", "GET", "", "192.0.2.6:1234", nil, 4},
+	}
+	for _, tc := range cases {
+		fetch(tc.method, tc.credential, tc.remoteAddr, tc.header)
+		got, err := api.db.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if got.ViewCount != tc.wantViews {
+			t.Errorf("%s: view count = %d, want %d", tc.name, got.ViewCount, tc.wantViews)
+		}
+	}
+}
+
+func TestHandlerVideosRetrieve(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	mine := api.createVideo(t, owner, "mine", database.VisibilityPrivate)
+	api.createVideo(t, other, "theirs", database.VisibilityPublic)
+
+	runStatusCases(t, api, []statusCase{
+		{"owner", "GET", "/api/videos", owner.token, nil, http.StatusOK},
+		{"invalid sort", "GET", "/api/videos?sort=random", owner.token, nil, http.StatusBadRequest},
+		{"invalid limit", "GET", "/api/videos?limit=1000", owner.token, nil, http.StatusBadRequest},
+		{"anonymous", "GET", "/api/videos", "", nil, http.StatusUnauthorized},
+	})
+
+	page := decodeBody[videoPage](t, api.do("GET", "/api/videos", owner.token, nil))
+	if len(page.Videos) != 1 || page.Videos[0].ID != mine.ID {
+		t.Errorf("listed %v, want only the caller's own video", page.Videos)
+	}
+}
+
+func TestHandlerVideoMetaUpdate(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "before", database.VisibilityPrivate)
+	target := "/api/videos/" + video.ID.String()
+
+	runStatusCases(t, api, []statusCase{
+		{"owner", "PATCH", target, owner.token, map[string]string{"title": "after", "visibility": "unlisted"}, http.StatusOK},
+		{"malformed body", "PATCH", target, owner.token, "{", http.StatusBadRequest},
+		{"invalid visibility", "PATCH", target, owner.token, map[string]string{"visibility": "secret"}, http.StatusBadRequest},
+		{"invalid ID", "PATCH", "/api/videos/not-a-uuid", owner.token, map[string]string{"title": "x"}, http.StatusBadRequest},
+		{"anonymous", "PATCH", target, "", map[string]string{"title": "x"}, http.StatusUnauthorized},
+		{"other user", "PATCH", target, other.token, map[string]string{"title": "x"}, http.StatusForbidden},
+		{"unknown", "PATCH", "/api/videos/" + uuid.NewString(), owner.token, map[string]string{"title": "x"}, http.StatusNotFound},
+	})
+
+	got, err := api.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if got.Title != "after" || got.Visibility != database.VisibilityUnlisted {
+		t.Errorf("video is %q and %s, want %q and %s", got.Title, got.Visibility, "after", database.VisibilityUnlisted)
+	}
+}
+
+func TestHandlerVideoMetaDelete(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	other := api.createUser(t, "other@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "doomed", database.VisibilityPublic)
+	target := "/api/videos/" + video.ID.String()
+
+	videoKey := "landscape/doomed.mp4"
+	streamKey := "streams/" + video.ID.String() + "/job/master.m3u8"
+	video.VideoKey = &videoKey
+	video.HLSManifestKey = &streamKey
+	if err := api.db.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	for _, key := range []string{videoKey, streamKey, "landscape/kept.mp4"} {
+		if err := api.storage.Put(context.Background(), key, strings.NewReader("data"), "video/mp4"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	runStatusCases(t, api, []statusCase{
+		{"invalid ID", "DELETE", "/api/videos/not-a-uuid", owner.token, nil, http.StatusBadRequest},
+		{"anonymous", "DELETE", target, "", nil, http.StatusUnauthorized},
+		{"other user", "DELETE", target, other.token, nil, http.StatusForbidden},
+		{"unknown", "DELETE", "/api/videos/" + uuid.NewString(), owner.token, nil, http.StatusNotFound},
+		{"owner", "DELETE", target, owner.token, nil, http.StatusNoContent},
+		{"already deleted", "DELETE", target, owner.token, nil, http.StatusNotFound},
+	})
+
+	for _, key := range []string{videoKey, streamKey} {
+		if _, err := api.storage.Stat(context.Background(), key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("%s wasn't deleted with the video: %v", key, err)
+		}
+	}
+	if _, err := api.storage.Stat(context.Background(), "landscape/kept.mp4"); err != nil {
+		t.Errorf("another video's file was deleted: %v", err)
+	}
+}
+
+func TestCDNVisibility(t *testing.T) {
+	api := newTestAPI(t)
+	api.cfg.deliveryMode = "cdn"
+	creator := api.createUser(t, "creator@example.com", database.RoleCreator)
+	public := api.createVideo(t, creator, "public", database.VisibilityPublic)
+	target := "/api/videos/" + public.ID.String()
+
+	runStatusCases(t, api, []statusCase{
+		{"public", "POST", "/api/videos", creator.token, map[string]string{"title": "Boots", "visibility": "public"}, http.StatusCreated},
+		{"default visibility", "POST", "/api/videos", creator.token, map[string]string{"title": "Boots"}, http.StatusBadRequest},
+		{"unlisted", "POST", "/api/videos", creator.token, map[string]string{"title": "Boots", "visibility": "unlisted"}, http.StatusBadRequest},
+		{"make private", "PATCH", target, creator.token, map[string]string{"visibility": "private"}, http.StatusBadRequest},
+	})
+
+	if err := api.cfg.checkCDNVisibility(); err != nil {
+		t.Errorf("checkCDNVisibility with only public videos: %v", err)
+	}
+	api.createVideo(t, creator, "unlisted", database.VisibilityUnlisted)
+	if err := api.cfg.checkCDNVisibility(); err == nil {
+		t.Error("checkCDNVisibility allowed an unlisted video")
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -34,50 +35,81 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// Claims is what an access token says about its bearer.
+type Claims struct {
+	UserID uuid.UUID
+	// Role is empty for tokens issued before roles existed.
+	Role string
+	// TokenVersion is the user's token version when the token was issued.
+	// Bumping the user's version revokes all of their access tokens.
+	TokenVersion int
+}
+
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Role    string `json:"role,omitempty"`
+	Version int    `json:"ver,omitempty"`
+}
+
 func MakeJWT(
 	userID uuid.UUID,
+	role string,
+	tokenVersion int,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Role:    role,
+		Version: tokenVersion,
 	})
 	return token.SignedString(signingKey)
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	claims, err := ParseJWT(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+// ParseJWT validates an access token and returns its claims.
+func ParseJWT(tokenString, tokenSecret string) (Claims, error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return Claims{}, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return Claims{}, fmt.Errorf("invalid user ID: %w", err)
 	}
-	return id, nil
+	return Claims{UserID: id, Role: claimsStruct.Role, TokenVersion: claimsStruct.Version}, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -94,12 +126,46 @@ func GetBearerToken(headers http.Header) (string, error) {
 }
 
 func MakeRefreshToken() (string, error) {
-	token := make([]byte, 32)
-	_, err := rand.Read(token)
+	return randomHex(32)
+}
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to scan for.
+const APIKeyPrefix = "tubely_"
+
+func MakeAPIKey() (string, error) {
+	key, err := randomHex(32)
 	if err != nil {
 		return "", err
 	}
-	return hex.EncodeToString(token), nil
+	return APIKeyPrefix + key, nil
+}
+
+// MakeEmailToken returns a token for a verification or password reset link.
+func MakeEmailToken() (string, error) {
+	return randomHex(32)
+}
+
+// MakeLoginChallenge returns a token that stands in for a correct password
+// until the second factor is checked.
+func MakeLoginChallenge() (string, error) {
+	return randomHex(32)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hash that API keys, emailed tokens, login
+// challenges and recovery codes are stored and looked up by. Unlike
+// passwords they are random, so a fast unsalted SHA-256 is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func GetAPIKey(headers http.Header) (string, error) {
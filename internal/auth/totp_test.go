@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// rfc6238Secret is the SHA1 key of RFC 6238 Appendix B, base32 encoded.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// The RFC 6238 Appendix B SHA1 vectors. The RFC uses 8 digits; a 6 digit
+// code is the same value mod 10^6, so its last 6 digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, v := range rfc6238Vectors {
+		want := v.code[len(v.code)-totpDigits:]
+		if got := totpCode(key, v.unix/totpPeriod); got != want {
+			t.Errorf("code at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		code := v.code[len(v.code)-totpDigits:]
+		at := time.Unix(v.unix, 0)
+		step, ok := ValidateTOTP(rfc6238Secret, code, at)
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s at %d) = %d, %v, want %d, true", code, v.unix, step, ok, v.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	const unix = 1111111111
+	step := int64(unix / totpPeriod)
+	code := totpCode([]byte("12345678901234567890"), step)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"same step", 0, true},
+		{"one step late", totpPeriod, true},
+		{"one step early", -totpPeriod, true},
+		{"two steps late", 2 * totpPeriod, false},
+		{"two steps early", -2 * totpPeriod, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(rfc6238Secret, code, time.Unix(unix+tt.offset, 0))
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP = %v, want %v", ok, tt.ok)
+			}
+			// The step returned is the one the code was made for, not now's
+			if ok && got != step {
+				t.Errorf("ValidateTOTP step = %d, want %d", got, step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsMalformed(t *testing.T) {
+	at := time.Unix(59, 0)
+	tests := []struct {
+		name, secret, code string
+	}{
+		{"wrong code", rfc6238Secret, "000000"},
+		{"too short", rfc6238Secret, "28708"},
+		{"too long", rfc6238Secret, "4287082"},
+		{"empty secret", "", "287082"},
+		{"invalid secret", "not base32!", "287082"},
+	}
+	for _, tt := range tests {
+		if _, ok := ValidateTOTP(tt.secret, tt.code, at); ok {
+			t.Errorf("%s: ValidateTOTP accepted %q", tt.name, tt.code)
+		}
+	}
+}
+
+// TestTOTPReplay checks that a valid code only logs in once: the step it was
+// made for is recorded in totp_last_step, and that step and earlier ones are
+// refused afterwards, even though they are still inside the window.
+func TestTOTPReplay(t *testing.T) {
+	db := database.NewMemoryStore()
+	user, err := db.CreateUser(database.CreateUserParams{Email: "user@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := db.SetPendingTOTPSecret(user.ID, rfc6238Secret); err != nil {
+		t.Fatalf("SetPendingTOTPSecret: %v", err)
+	}
+	if err := db.EnableTOTP(user.ID, rfc6238Secret, 0, nil); err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+
+	key := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	login := func(code string) bool {
+		step, ok := ValidateTOTP(rfc6238Secret, code, now)
+		if !ok {
+			return false
+		}
+		ok, err := db.UseTOTPStep(user.ID, step)
+		if err != nil {
+			t.Fatalf("UseTOTPStep: %v", err)
+		}
+		return ok
+	}
+
+	if !login(totpCode(key, current)) {
+		t.Fatal("a fresh code was refused")
+	}
+	if login(totpCode(key, current)) {
+		t.Error("the same code was accepted twice")
+	}
+	if login(totpCode(key, current-1)) {
+		t.Error("a code for an earlier step was accepted after a later one")
+	}
+	if !login(totpCode(key, current+1)) {
+		t.Error("a code for the next step was refused")
+	}
+}
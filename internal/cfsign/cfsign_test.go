@@ -0,0 +1,248 @@
+package cfsign
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKeyPairID = "K2JCJMDEHXQW5F"
+
+func newTestSigner(t *testing.T) (*Signer, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return NewSigner(testKeyPairID, key), key
+}
+
+// checkCloudFrontBase64 fails if s uses any character CloudFront replaces
+// in its base64 variant.
+func checkCloudFrontBase64(t *testing.T, name, s string) {
+	t.Helper()
+	if s == "" {
+		t.Fatalf("%s is empty", name)
+	}
+	if strings.ContainsAny(s, "+=/") {
+		t.Errorf("%s %q contains + = or /", name, s)
+	}
+}
+
+// verifyRaw checks a signature the way CloudFront does, without going
+// through the package's own verify.
+func verifyRaw(t *testing.T, pub *rsa.PublicKey, policy, signature string) {
+	t.Helper()
+	std := strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(signature)
+	sig, err := base64.StdEncoding.DecodeString(std)
+	if err != nil {
+		t.Fatalf("signature isn't CloudFront base64: %v", err)
+	}
+	hash := sha1.Sum([]byte(policy))
+	err = rsa.VerifyPKCS1v15(pub, crypto.SHA1, hash[:], sig)
+	if err != nil {
+		t.Fatalf("signature doesn't verify against the policy: %v", err)
+	}
+}
+
+func TestSignURLCannedPolicy(t *testing.T) {
+	signer, key := newTestSigner(t)
+	expires := time.Unix(1700000000, 0)
+	now := expires.Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		url  string
+		sep  string
+	}{
+		{"no query", "https://d111111abcdef8.cloudfront.net/landscape/abc.mp4", "?"},
+		{"with query", "https://d111111abcdef8.cloudfront.net/landscape/abc.mp4?quality=hd&x=1", "&"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := signer.SignURL(tt.url, expires)
+			if err != nil {
+				t.Fatalf("SignURL: %v", err)
+			}
+			if !strings.HasPrefix(signed, tt.url+tt.sep+"Expires=1700000000&Signature=") {
+				t.Fatalf("signed URL %q doesn't append the parameters to the original URL", signed)
+			}
+
+			u, err := url.Parse(signed)
+			if err != nil {
+				t.Fatalf("signed URL doesn't parse: %v", err)
+			}
+			if got := u.Query().Get("Key-Pair-Id"); got != testKeyPairID {
+				t.Errorf("Key-Pair-Id = %q, want %q", got, testKeyPairID)
+			}
+			signature := u.Query().Get("Signature")
+			checkCloudFrontBase64(t, "Signature", signature)
+
+			canned := fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":1700000000}}}]}`, tt.url)
+			verifyRaw(t, &key.PublicKey, canned, signature)
+
+			err = VerifyURL(signed, &key.PublicKey, now)
+			if err != nil {
+				t.Errorf("VerifyURL: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyURLRejects(t *testing.T) {
+	signer, key := newTestSigner(t)
+	other, _ := newTestSigner(t)
+	expires := time.Unix(1700000000, 0)
+	rawURL := "https://d111111abcdef8.cloudfront.net/landscape/abc.mp4"
+
+	signed, err := signer.SignURL(rawURL, expires)
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+	signedByOther, err := other.SignURL(rawURL, expires)
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		now  time.Time
+		want error
+	}{
+		{"expired", signed, expires, ErrExpired},
+		{"other path", strings.Replace(signed, "abc.mp4", "xyz.mp4", 1), expires.Add(-time.Minute), ErrInvalidSignature},
+		{"extended expiry", strings.Replace(signed, "Expires=1700000000", "Expires=1800000000", 1), expires.Add(-time.Minute), ErrInvalidSignature},
+		{"other key", signedByOther, expires.Add(-time.Minute), ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyURL(tt.url, &key.PublicKey, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyURL = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedCookiesCustomPolicy(t *testing.T) {
+	signer, key := newTestSigner(t)
+	expires := time.Unix(1700000000, 0)
+	now := expires.Add(-time.Minute)
+	resource := "https://d111111abcdef8.cloudfront.net/streams/8c1d/*"
+
+	cookies, err := signer.SignedCookies(resource, expires)
+	if err != nil {
+		t.Fatalf("SignedCookies: %v", err)
+	}
+	values := map[string]string{}
+	for _, cookie := range cookies {
+		values[cookie.Name] = cookie.Value
+	}
+	if got := values["CloudFront-Key-Pair-Id"]; got != testKeyPairID {
+		t.Errorf("CloudFront-Key-Pair-Id = %q, want %q", got, testKeyPairID)
+	}
+	checkCloudFrontBase64(t, "CloudFront-Policy", values["CloudFront-Policy"])
+	checkCloudFrontBase64(t, "CloudFront-Signature", values["CloudFront-Signature"])
+
+	policy, err := decode(values["CloudFront-Policy"])
+	if err != nil {
+		t.Fatalf("policy isn't CloudFront base64: %v", err)
+	}
+	want := `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/streams/8c1d/*","Condition":{"DateLessThan":{"AWS:EpochTime":1700000000}}}]}`
+	if string(policy) != want {
+		t.Fatalf("policy = %s, want %s", policy, want)
+	}
+	verifyRaw(t, &key.PublicKey, want, values["CloudFront-Signature"])
+
+	tests := []struct {
+		name string
+		url  string
+		now  time.Time
+		want error
+	}{
+		{"manifest", "https://d111111abcdef8.cloudfront.net/streams/8c1d/master.m3u8", now, nil},
+		{"segment", "https://d111111abcdef8.cloudfront.net/streams/8c1d/chunk-0-00001.m4s", now, nil},
+		{"other video", "https://d111111abcdef8.cloudfront.net/streams/9f2e/master.m3u8", now, ErrResourceMismatch},
+		{"expired", "https://d111111abcdef8.cloudfront.net/streams/8c1d/master.m3u8", expires, ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyCookies(cookies, tt.url, &key.PublicKey, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyCookies = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	tampered := []*http.Cookie{
+		{Name: "CloudFront-Policy", Value: encode([]byte(strings.Replace(want, "8c1d", "9f2e", 1)))},
+		{Name: "CloudFront-Signature", Value: values["CloudFront-Signature"]},
+	}
+	err = VerifyCookies(tampered, "https://d111111abcdef8.cloudfront.net/streams/9f2e/master.m3u8", &key.PublicKey, now)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("VerifyCookies with a tampered policy = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestEncodeUsesCloudFrontAlphabet(t *testing.T) {
+	// Standard base64 of these bytes is "+/+/", plus padding for the odd length
+	data := []byte{0xfb, 0xff, 0xbf, 0xfb}
+	got := encode(data)
+	if got != "-~-~-w__" {
+		t.Fatalf("encode = %q, want %q", got, "-~-~-w__")
+	}
+	decoded, err := decode(got)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(decoded) != string(data) {
+		t.Errorf("decode = %x, want %x", decoded, data)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		pem  []byte
+	}{
+		{"PKCS#1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})},
+		{"PKCS#8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParsePrivateKey(tt.pem)
+			if err != nil {
+				t.Fatalf("ParsePrivateKey: %v", err)
+			}
+			if !parsed.Equal(key) {
+				t.Error("parsed key doesn't match the original")
+			}
+		})
+	}
+
+	_, err = ParsePrivateKey([]byte("not a key"))
+	if err == nil {
+		t.Error("ParsePrivateKey accepted data without a PEM block")
+	}
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyScope limits what an API key can be used for. Each route that
+// accepts API keys names the scopes that let a key call it.
+type APIKeyScope string
+
+const (
+	// ScopeVideosRead lists, fetches and searches videos and checks jobs.
+	ScopeVideosRead APIKeyScope = "videos:read"
+	// ScopeVideosUpload creates videos and uploads their files.
+	ScopeVideosUpload APIKeyScope = "videos:upload"
+	// ScopeVideosWrite changes and deletes existing videos.
+	ScopeVideosWrite APIKeyScope = "videos:write"
+)
+
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case ScopeVideosRead, ScopeVideosUpload, ScopeVideosWrite:
+		return true
+	}
+	return false
+}
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// Prefix is the start of the key, shown so users can tell keys apart.
+	Prefix string `json:"prefix"`
+	// KeyHash is the SHA-256 hash of the key, which itself is never stored.
+	KeyHash string        `json:"-"`
+	Scopes  []APIKeyScope `json:"scopes"`
+}
+
+const apiKeyColumns = `id, created_at, updated_at, last_used_at, revoked_at, user_id, name, prefix, key_hash, scopes`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var key APIKey
+	var id, userID, scopes string
+	err := row.Scan(
+		&id,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&userID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.ID, err = uuid.Parse(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = []APIKeyScope{}
+	for _, s := range strings.Split(scopes, ",") {
+		if s != "" {
+			key.Scopes = append(key.Scopes, APIKeyScope(s))
+		}
+	}
+	return key, nil
+}
+
+func joinScopes(scopes []APIKeyScope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, ",")
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+		INSERT INTO api_keys (
+			id,
+			created_at,
+			updated_at,
+			user_id,
+			name,
+			prefix,
+			key_hash,
+			scopes
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id.String(),
+		params.UserID.String(),
+		params.Name,
+		params.Prefix,
+		params.KeyHash,
+		joinScopes(params.Scopes),
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.GetAPIKey(id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE id = ?
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, id.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrNotFound
+	}
+	return key, err
+}
+
+// GetAPIKeyByHash looks up the key a request authenticated with. Revoked keys
+// are returned too, with RevokedAt set.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE key_hash = ?
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrNotFound
+	}
+	return key, err
+}
+
+// GetAPIKeys returns a user's keys that haven't been revoked, oldest first.
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY created_at, id
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) MarkAPIKeyUsed(id uuid.UUID) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
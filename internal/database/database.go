@@ -2,88 +2,89 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// ErrNotFound is returned by getters when there is no matching row.
+var ErrNotFound = errors.New("not found")
+
 type Client struct {
-	db *sql.DB
+	db   conn
+	fts5 bool
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+// NewClient opens the database and applies any pending migrations.
+func NewClient(dbURL string) (Client, error) {
+	c, err := Open(dbURL)
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	_, err = c.MigrateUp()
 	if err != nil {
 		return Client{}, err
 	}
+	if c.db.dialect == dialectSQLite {
+		err = c.migrateSearch()
+		if err != nil {
+			return Client{}, err
+		}
+	}
 	return c, nil
-
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
+// Open connects to Postgres when dbURL is a Postgres connection string and
+// otherwise opens dbURL as a SQLite file. Unlike NewClient it leaves the
+// schema alone.
+func Open(dbURL string) (Client, error) {
+	d := dialectFor(dbURL)
+	db, err := sql.Open(string(d), dbURL)
 	if err != nil {
-		return err
-	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
-	if err != nil {
-		return err
+		return Client{}, err
 	}
+	return Client{db: conn{DB: db, dialect: d}}, nil
+}
 
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
-	}
-	return nil
+func (c Client) Close() error {
+	return c.db.Close()
 }
 
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM direct_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table direct_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM uploads"); err != nil {
+		return fmt.Errorf("failed to reset table uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM login_challenges"); err != nil {
+		return fmt.Errorf("failed to reset table login_challenges: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM email_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table email_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+	if _, err := c.db.Exec("DELETE FROM video_assets"); err != nil {
+		return fmt.Errorf("failed to reset table video_assets: %w", err)
 	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
+		return fmt.Errorf("failed to reset table users: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect is the SQL flavor of the database behind a Client. Queries are
+// written once with ? placeholders and rebound for Postgres.
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite3"
+	dialectPostgres dialect = "postgres"
+)
+
+// dialectFor picks the driver from a DB_URL style setting. Postgres URLs
+// and key=value connection strings go to Postgres, anything else is treated
+// as a SQLite file path.
+func dialectFor(dbURL string) dialect {
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		return dialectPostgres
+	}
+	if strings.Contains(dbURL, "host=") || strings.Contains(dbURL, "dbname=") {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+// rebind rewrites ? placeholders into Postgres' numbered $1, $2, ... form,
+// leaving question marks inside string literals alone.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			inString = !inString
+			b.WriteByte(ch)
+		case ch == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// timeArg converts a time for comparison against a timestamp column.
+// SQLite keeps CURRENT_TIMESTAMP values as text, so times must be formatted
+// the same way to compare correctly.
+func (d dialect) timeArg(t time.Time) any {
+	if d == dialectSQLite {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	}
+	return t
+}
+
+// conn rebinds every query for its dialect, so Client methods can use one
+// query string for both databases.
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Begin() (tx, error) {
+	t, err := c.DB.Begin()
+	return tx{Tx: t, dialect: c.dialect}, err
+}
+
+type tx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t tx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
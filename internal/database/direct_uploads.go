@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DirectUpload is a storage key handed out for a client to upload a video
+// to with presigned URLs.
+type DirectUpload struct {
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreateDirectUploadParams
+}
+
+type CreateDirectUploadParams struct {
+	Key     string    `json:"key"`
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	// UploadID is the multipart upload's ID, empty for a single PUT.
+	UploadID  string    `json:"upload_id"`
+	Size      int64     `json:"size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateDirectUpload(params CreateDirectUploadParams) error {
+	query := `
+	INSERT INTO direct_uploads (
+		key,
+		created_at,
+		video_id,
+		user_id,
+		upload_id,
+		size,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		params.Key,
+		params.VideoID,
+		params.UserID,
+		params.UploadID,
+		params.Size,
+		c.db.dialect.timeArg(params.ExpiresAt),
+	)
+	return err
+}
+
+// CompleteDirectUpload uses up the direct upload issued for key on video
+// and returns it. Unknown, expired and already completed uploads, and keys
+// issued for another video, are all ErrNotFound.
+func (c Client) CompleteDirectUpload(key string, videoID uuid.UUID) (DirectUpload, error) {
+	query := `
+	UPDATE direct_uploads
+	SET completed_at = CURRENT_TIMESTAMP
+	WHERE key = ? AND video_id = ? AND completed_at IS NULL AND expires_at > ?
+	RETURNING
+		key,
+		created_at,
+		video_id,
+		user_id,
+		upload_id,
+		size,
+		expires_at,
+		completed_at
+	`
+	var upload DirectUpload
+	err := c.db.QueryRow(query, key, videoID, c.db.dialect.timeArg(time.Now())).Scan(
+		&upload.Key,
+		&upload.CreatedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.UploadID,
+		&upload.Size,
+		&upload.ExpiresAt,
+		&upload.CompletedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DirectUpload{}, ErrNotFound
+	}
+	if err != nil {
+		return DirectUpload{}, err
+	}
+	return upload, nil
+}
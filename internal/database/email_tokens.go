@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailTokenPurpose says what an emailed token may be used for.
+type EmailTokenPurpose string
+
+const (
+	EmailTokenVerifyEmail   EmailTokenPurpose = "verify_email"
+	EmailTokenResetPassword EmailTokenPurpose = "reset_password"
+)
+
+type EmailToken struct {
+	CreateEmailTokenParams
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// CreateEmailTokenParams describes a token sent by email. Only its hash is
+// stored, so a database leak doesn't leak working links.
+type CreateEmailTokenParams struct {
+	TokenHash string            `json:"-"`
+	UserID    uuid.UUID         `json:"user_id"`
+	Purpose   EmailTokenPurpose `json:"purpose"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+func (c Client) CreateEmailToken(params CreateEmailTokenParams) error {
+	query := `
+		INSERT INTO email_tokens (
+			token_hash,
+			created_at,
+			user_id,
+			purpose,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.TokenHash, params.UserID.String(), params.Purpose, params.ExpiresAt)
+	return err
+}
+
+// ConsumeEmailToken uses up a token for purpose and returns the user it was
+// sent to. Tokens are single use, so the user's other unused tokens for the
+// same purpose are used up with it. Unknown, expired and used tokens are all
+// ErrNotFound.
+func (c Client) ConsumeEmailToken(tokenHash string, purpose EmailTokenPurpose) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	var expiresAt time.Time
+	var usedAt *time.Time
+	err = tx.QueryRow(`
+		SELECT user_id, expires_at, used_at
+		FROM email_tokens
+		WHERE token_hash = ? AND purpose = ?
+	`, tokenHash, purpose).Scan(&userID, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, ErrNotFound
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if usedAt != nil || time.Now().After(expiresAt) {
+		return uuid.Nil, ErrNotFound
+	}
+
+	// Only one of two requests racing for the same token gets to use it
+	res, err := tx.Exec(`
+		UPDATE email_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL
+	`, tokenHash)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	used, err := res.RowsAffected()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if used == 0 {
+		return uuid.Nil, ErrNotFound
+	}
+
+	_, err = tx.Exec(`
+		UPDATE email_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, userID, purpose)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(userID)
+}
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusPending    JobStatus = "pending"
+	JobStatusProcessing JobStatus = "processing"
+	JobStatusCompleted  JobStatus = "completed"
+	JobStatusFailed     JobStatus = "failed"
+)
+
+type Job struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Status      JobStatus  `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   *string    `json:"last_error"`
+	RunAt       time.Time  `json:"run_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// LeaseExpiresAt is when a processing job is given up on unless the
+	// worker running it extends the lease.
+	LeaseExpiresAt *time.Time `json:"-"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	InputKey    string    `json:"-"`
+	ContentType string    `json:"-"`
+	MaxAttempts int       `json:"max_attempts"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		status,
+		attempts,
+		last_error,
+		run_at,
+		completed_at,
+		lease_expires_at,
+		video_id,
+		user_id,
+		input_key,
+		content_type,
+		max_attempts
+`
+
+func scanJob(row interface{ Scan(...any) error }) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Status,
+		&job.Attempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.CompletedAt,
+		&job.LeaseExpiresAt,
+		&job.VideoID,
+		&job.UserID,
+		&job.InputKey,
+		&job.ContentType,
+		&job.MaxAttempts,
+	)
+	return job, err
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	if params.MaxAttempts < 1 {
+		params.MaxAttempts = 1
+	}
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		status,
+		attempts,
+		run_at,
+		video_id,
+		user_id,
+		input_key,
+		content_type,
+		max_attempts
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, 0, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		JobStatusPending,
+		c.db.dialect.timeArg(time.Now()),
+		params.VideoID,
+		params.UserID,
+		params.InputKey,
+		params.ContentType,
+		params.MaxAttempts,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `SELECT` + jobColumns + `FROM jobs WHERE id = ?`
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, ErrNotFound
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimNextJob atomically moves the oldest runnable pending job to
+// processing, leased until leaseUntil, and returns it. It returns nil when
+// there is nothing to do.
+func (c Client) ClaimNextJob(leaseUntil time.Time) (*Job, error) {
+	// SQLite serializes writers, but Postgres workers need to skip jobs
+	// another worker is claiming at the same moment
+	lock := ""
+	if c.db.dialect == dialectPostgres {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		lease_expires_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE status = ? AND run_at <= ?
+		ORDER BY run_at
+		LIMIT 1
+		` + lock + `
+	)
+	RETURNING` + jobColumns
+
+	job, err := scanJob(c.db.QueryRow(query, JobStatusProcessing, c.db.dialect.timeArg(leaseUntil), JobStatusPending, c.db.dialect.timeArg(time.Now())))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ExtendJobLease keeps the lease on a job the caller is still running.
+// attempt is the job's Attempts when it was claimed; if the lease already
+// ran out and the job was claimed again since, it returns ErrNotFound and
+// the caller should stop.
+func (c Client) ExtendJobLease(id uuid.UUID, attempt int, leaseUntil time.Time) error {
+	query := `
+	UPDATE jobs
+	SET
+		lease_expires_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ? AND attempts = ?
+	`
+	res, err := c.db.Exec(query, c.db.dialect.timeArg(leaseUntil), id, JobStatusProcessing, attempt)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = NULL,
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusCompleted, id)
+	return err
+}
+
+// RetryJob puts a job back in the queue to be picked up again at runAt.
+func (c Client) RetryJob(id uuid.UUID, lastError string, runAt time.Time) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		run_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusPending, lastError, c.db.dialect.timeArg(runAt), id)
+	return err
+}
+
+func (c Client) FailJob(id uuid.UUID, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusFailed, lastError, id)
+	return err
+}
+
+// RequeueExpiredJobs returns processing jobs whose lease ran out before
+// now, because the worker running them stopped, to the pending state. Jobs
+// from before leases existed have none and are requeued too.
+func (c Client) RequeueExpiredJobs(now time.Time) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		lease_expires_at = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)
+	`
+	_, err := c.db.Exec(query, JobStatusPending, JobStatusProcessing, c.db.dialect.timeArg(now))
+	return err
+}
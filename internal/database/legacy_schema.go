@@ -0,0 +1,262 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// upgradeLegacySchema brings a SQLite database created before versioned
+// migrations up to the layout of the first migration, so it can be adopted
+// as version 1. Each step checks what is already there, since older versions
+// of Tubely left databases in many different states.
+func (c *Client) upgradeLegacySchema() error {
+	userTable := `
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		password TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL
+	);
+	`
+	_, err := c.db.Exec(userTable)
+	if err != nil {
+		return err
+	}
+	refreshTokenTable := `
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(refreshTokenTable)
+	if err != nil {
+		return err
+	}
+
+	videoTable := `
+	CREATE TABLE IF NOT EXISTS videos (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		thumbnail_url TEXT,
+		thumbnail TEXT,
+		video_url TEXT TEXT,
+		user_id INTEGER,
+		processing_status TEXT NOT NULL DEFAULT 'pending',
+		hls_manifest_url TEXT,
+		dash_manifest_url TEXT,
+		video_key TEXT,
+		hls_manifest_key TEXT,
+		dash_manifest_key TEXT,
+		video_bucket TEXT,
+		visibility TEXT NOT NULL DEFAULT 'private',
+		aspect_ratio TEXT,
+		view_count INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoTable)
+	if err != nil {
+		return err
+	}
+
+	added, err := c.ensureColumn("videos", "processing_status", "TEXT NOT NULL DEFAULT 'pending'")
+	if err != nil {
+		return err
+	}
+	if added {
+		_, err = c.db.Exec("UPDATE videos SET processing_status = 'ready' WHERE video_url IS NOT NULL")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = c.ensureColumn("videos", "hls_manifest_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	_, err = c.ensureColumn("videos", "dash_manifest_url", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// Thumbnails used to be a single URL, carry those over as a thumbnail
+	// without any resized renditions.
+	added, err = c.ensureColumn("videos", "thumbnail", "TEXT")
+	if err != nil {
+		return err
+	}
+	if added {
+		_, err = c.db.Exec(`
+		UPDATE videos
+		SET thumbnail = json_object('src', thumbnail_url, 'sizes', json('[]'))
+		WHERE thumbnail_url IS NOT NULL
+		`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Older rows stored finished URLs, which break whenever the host or CDN
+	// changes. Store keys instead and convert what is already there.
+	_, err = c.ensureColumn("videos", "hls_manifest_key", "TEXT")
+	if err != nil {
+		return err
+	}
+	_, err = c.ensureColumn("videos", "dash_manifest_key", "TEXT")
+	if err != nil {
+		return err
+	}
+	added, err = c.ensureColumn("videos", "video_key", "TEXT")
+	if err != nil {
+		return err
+	}
+	if added {
+		err = c.migrateURLsToKeys()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Rows from before buckets were recorded are resolved against the
+	// configured bucket
+	_, err = c.ensureColumn("videos", "video_bucket", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// Anyone with the ID could watch videos from before visibility existed,
+	// so they start out unlisted rather than disappearing
+	added, err = c.ensureColumn("videos", "visibility", "TEXT NOT NULL DEFAULT 'private'")
+	if err != nil {
+		return err
+	}
+	if added {
+		_, err = c.db.Exec("UPDATE videos SET visibility = 'unlisted'")
+		if err != nil {
+			return err
+		}
+	}
+
+	// The aspect ratio category used to only be recorded as the key prefix
+	added, err = c.ensureColumn("videos", "aspect_ratio", "TEXT")
+	if err != nil {
+		return err
+	}
+	if added {
+		_, err = c.db.Exec(`
+		UPDATE videos
+		SET aspect_ratio = substr(video_key, 1, instr(video_key, '/') - 1)
+		WHERE video_key LIKE 'landscape/%'
+			OR video_key LIKE 'portrait/%'
+			OR video_key LIKE 'other/%'
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = c.ensureColumn("videos", "view_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// Keyset pagination walks these in order for the feed and for a user's
+	// own videos
+	_, err = c.db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_videos_visibility_created ON videos (visibility, created_at, id);
+	CREATE INDEX IF NOT EXISTS idx_videos_user_created ON videos (user_id, created_at, id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL,
+		last_error TEXT,
+		run_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		input_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	uploadTable := `
+	CREATE TABLE IF NOT EXISTS uploads (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		upload_length INTEGER NOT NULL,
+		upload_offset INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL,
+		metadata TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(uploadTable)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ensureColumn adds a column to a table created by an older version of
+// Tubely. It reports whether the column had to be added.
+func (c *Client) ensureColumn(table, column, definition string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// migrateURLsToKeys converts rows written when Tubely stored finished URLs
+// into the storage keys it stores now.
+func (c *Client) migrateURLsToKeys() error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT id, video_url, hls_manifest_url, dash_manifest_url, thumbnail
+	FROM videos
+	`)
+	if err != nil {
+		return err
+	}
+	type legacyRow struct {
+		id                                   string
+		videoURL, hlsURL, dashURL, thumbnail sql.NullString
+	}
+	var legacy []legacyRow
+	for rows.Next() {
+		var row legacyRow
+		if err := rows.Scan(&row.id, &row.videoURL, &row.hlsURL, &row.dashURL, &row.thumbnail); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, row := range legacy {
+		var thumbnail *Thumbnail
+		if row.thumbnail.Valid {
+			thumbnail, err = legacyThumbnail(row.thumbnail.String)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`
+		UPDATE videos
+		SET video_key = ?, hls_manifest_key = ?, dash_manifest_key = ?, thumbnail = ?
+		WHERE id = ?
+		`,
+			legacyKey(row.videoURL),
+			legacyKey(row.hlsURL),
+			legacyKey(row.dashURL),
+			thumbnail,
+			row.id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// legacyThumbnail converts a thumbnail stored with URLs into one that only
+// has keys.
+func legacyThumbnail(data string) (*Thumbnail, error) {
+	var legacy struct {
+		Src   string `json:"src"`
+		Sizes []struct {
+			Name   string `json:"name"`
+			Width  int    `json:"width"`
+			Height int    `json:"height"`
+			JPEG   string `json:"jpeg"`
+			WebP   string `json:"webp"`
+		} `json:"sizes"`
+	}
+	if err := json.Unmarshal([]byte(data), &legacy); err != nil {
+		return nil, err
+	}
+
+	thumbnail := &Thumbnail{SrcKey: keyFromURL(legacy.Src)}
+	for _, size := range legacy.Sizes {
+		thumbnail.Sizes = append(thumbnail.Sizes, ThumbnailSize{
+			Name:    size.Name,
+			Width:   size.Width,
+			Height:  size.Height,
+			JPEGKey: keyFromURL(size.JPEG),
+			WebPKey: keyFromURL(size.WebP),
+		})
+	}
+	return thumbnail, nil
+}
+
+func legacyKey(u sql.NullString) *string {
+	if !u.Valid {
+		return nil
+	}
+	key := keyFromURL(u.String)
+	return &key
+}
+
+// keyFromURL recovers the storage key from an old URL. Those were either
+// http://host/assets/<key> for local files or <CDN domain>/<key> for S3, and
+// the CDN domain was not always configured with a scheme.
+func keyFromURL(raw string) string {
+	if !strings.Contains(raw, "://") {
+		host, rest, found := strings.Cut(raw, "/")
+		// Keys never have a dot in their first segment, host names do
+		if !found || !strings.Contains(host, ".") {
+			return raw
+		}
+		raw = "https://" + host + "/" + rest
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	return strings.TrimPrefix(key, "assets/")
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreateLoginChallengeParams describes the token handed out after a correct
+// password when the user has two-factor authentication on. Only its hash is
+// stored.
+type CreateLoginChallengeParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+func (c Client) CreateLoginChallenge(params CreateLoginChallengeParams) error {
+	query := `
+		INSERT INTO login_challenges (
+			token_hash,
+			created_at,
+			user_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.TokenHash, params.UserID.String(), params.ExpiresAt)
+	return err
+}
+
+// AttemptLoginChallenge counts an attempt at answering a challenge and
+// returns the user it was issued to. Unknown and expired challenges, and
+// those already attempted maxAttempts times, are ErrNotFound.
+func (c Client) AttemptLoginChallenge(tokenHash string, maxAttempts int) (uuid.UUID, error) {
+	var userID string
+	var expiresAt time.Time
+	var attempts int
+	err := c.db.QueryRow(`
+		SELECT user_id, expires_at, attempts
+		FROM login_challenges
+		WHERE token_hash = ?
+	`, tokenHash).Scan(&userID, &expiresAt, &attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, ErrNotFound
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if attempts >= maxAttempts || time.Now().After(expiresAt) {
+		return uuid.Nil, ErrNotFound
+	}
+
+	// Concurrent attempts can't share a count
+	res, err := c.db.Exec(`
+		UPDATE login_challenges
+		SET attempts = attempts + 1
+		WHERE token_hash = ? AND attempts = ?
+	`, tokenHash, attempts)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if updated == 0 {
+		return uuid.Nil, ErrNotFound
+	}
+
+	return uuid.Parse(userID)
+}
+
+func (c Client) DeleteLoginChallenge(tokenHash string) error {
+	query := `
+		DELETE FROM login_challenges
+		WHERE token_hash = ?
+	`
+	_, err := c.db.Exec(query, tokenHash)
+	return err
+}
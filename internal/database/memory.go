@@ -0,0 +1,943 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a Store that keeps everything in maps, so handlers can be
+// tested without a database file. It is safe for concurrent use.
+type MemoryStore struct {
+	mu          sync.Mutex
+	users       map[uuid.UUID]User
+	videos      map[uuid.UUID]Video
+	tokens      map[string]RefreshToken
+	emailTokens map[string]EmailToken
+	// totpSteps is each user's totp_last_step.
+	totpSteps map[uuid.UUID]int64
+	// recoveryCodes maps a user to their unused recovery code hashes.
+	recoveryCodes   map[uuid.UUID]map[string]bool
+	loginChallenges map[string]loginChallenge
+	apiKeys         map[uuid.UUID]APIKey
+	jobs            map[uuid.UUID]Job
+	uploads         map[uuid.UUID]Upload
+	directUploads   map[string]DirectUpload
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.Reset()
+	return s
+}
+
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = map[uuid.UUID]User{}
+	s.videos = map[uuid.UUID]Video{}
+	s.tokens = map[string]RefreshToken{}
+	s.emailTokens = map[string]EmailToken{}
+	s.totpSteps = map[uuid.UUID]int64{}
+	s.recoveryCodes = map[uuid.UUID]map[string]bool{}
+	s.loginChallenges = map[string]loginChallenge{}
+	s.apiKeys = map[uuid.UUID]APIKey{}
+	s.jobs = map[uuid.UUID]Job{}
+	s.uploads = map[uuid.UUID]Upload{}
+	s.directUploads = map[string]DirectUpload{}
+	return nil
+}
+
+// now matches the precision of CURRENT_TIMESTAMP in SQLite.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (s *MemoryStore) CreateUser(params CreateUserParams) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == params.Email {
+			return nil, fmt.Errorf("a user with email %s already exists", params.Email)
+		}
+	}
+	user := User{ID: uuid.New(), CreatedAt: now(), UpdatedAt: now(), Role: RoleCreator, CreateUserParams: params}
+	s.users[user.ID] = user
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUser(id uuid.UUID) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []User{}
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].Email < users[j].Email
+	})
+	return users, nil
+}
+
+func (s *MemoryStore) UpdateUserRole(id uuid.UUID, role Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Role = role
+	user.UpdatedAt = now()
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) UpdateUserPassword(id uuid.UUID, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Password = password
+	user.TokenVersion++
+	user.UpdatedAt = now()
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) MarkEmailVerified(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok || user.EmailVerifiedAt != nil {
+		return nil
+	}
+	verifiedAt := now()
+	user.EmailVerifiedAt = &verifiedAt
+	user.UpdatedAt = now()
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, id)
+	return nil
+}
+
+// storedVideo copies a video the way a database round trip would: nothing
+// is shared with the caller's copy and the URL fields are dropped.
+func storedVideo(video Video) Video {
+	clone := func(p *string) *string {
+		if p == nil {
+			return nil
+		}
+		v := *p
+		return &v
+	}
+	video.VideoBucket = clone(video.VideoBucket)
+	video.VideoKey = clone(video.VideoKey)
+	video.HLSManifestKey = clone(video.HLSManifestKey)
+	video.DASHManifestKey = clone(video.DASHManifestKey)
+	video.AspectRatio = clone(video.AspectRatio)
+	video.VideoURL, video.HLSManifestURL, video.DASHManifestURL = nil, nil, nil
+	if video.Thumbnail != nil {
+		thumb := *video.Thumbnail
+		thumb.Src = ""
+		thumb.Sizes = make([]ThumbnailSize, len(video.Thumbnail.Sizes))
+		for i, size := range video.Thumbnail.Sizes {
+			size.JPEG, size.WebP = "", ""
+			thumb.Sizes[i] = size
+		}
+		video.Thumbnail = &thumb
+	}
+	video.StreamingFormats = streamingFormats(video)
+	return video
+}
+
+func (s *MemoryStore) CreateVideo(params CreateVideoParams) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
+	video := Video{
+		ID:                uuid.New(),
+		CreatedAt:         now(),
+		UpdatedAt:         now(),
+		ProcessingStatus:  ProcessingStatusPending,
+		CreateVideoParams: params,
+	}
+	s.videos[video.ID] = storedVideo(video)
+	return storedVideo(video), nil
+}
+
+func (s *MemoryStore) GetVideo(id uuid.UUID) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, ok := s.videos[id]
+	if !ok {
+		return Video{}, ErrNotFound
+	}
+	return storedVideo(video), nil
+}
+
+func (s *MemoryStore) GetVideoByAssetKey(key string) (Video, error) {
+	if rest, ok := strings.CutPrefix(key, "streams/"); ok {
+		id, err := uuid.Parse(strings.SplitN(rest, "/", 2)[0])
+		if err != nil {
+			return Video{}, ErrNotFound
+		}
+		return s.GetVideo(id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, video := range s.videos {
+		if video.VideoKey != nil && *video.VideoKey == key {
+			return storedVideo(video), nil
+		}
+		if video.Thumbnail == nil {
+			continue
+		}
+		for _, size := range video.Thumbnail.Sizes {
+			if size.JPEGKey == key || size.WebPKey == key {
+				return storedVideo(video), nil
+			}
+		}
+	}
+	return Video{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetVideos(userID uuid.UUID) ([]Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		if video.UserID == userID {
+			videos = append(videos, storedVideo(video))
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.After(videos[j].CreatedAt)
+	})
+	return videos, nil
+}
+
+func (s *MemoryStore) UpdateVideo(video Video) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.videos[video.ID]
+	if !ok {
+		return nil
+	}
+	// Only the columns UpdateVideo writes in SQL
+	video.CreatedAt = old.CreatedAt
+	video.ViewCount = old.ViewCount
+	video.Hidden = old.Hidden
+	video.UpdatedAt = now()
+	s.videos[video.ID] = storedVideo(video)
+	return nil
+}
+
+func (s *MemoryStore) UpdateVideoProcessingStatus(id uuid.UUID, status ProcessingStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if video, ok := s.videos[id]; ok {
+		video.ProcessingStatus = status
+		video.UpdatedAt = now()
+		s.videos[id] = video
+	}
+	return nil
+}
+
+func (s *MemoryStore) IncrementVideoViews(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if video, ok := s.videos[id]; ok {
+		video.ViewCount++
+		s.videos[id] = video
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetVideoHidden(id uuid.UUID, hidden bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if video, ok := s.videos[id]; ok {
+		video.Hidden = hidden
+		video.UpdatedAt = now()
+		s.videos[id] = video
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteVideo(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.videos, id)
+	return nil
+}
+
+// QueryVideos pages through videos in the same order as Client.QueryVideos.
+func (s *MemoryStore) QueryVideos(q VideoQuery) ([]Video, *VideoCursor, error) {
+	if q.Sort == "" {
+		q.Sort = VideoSortNewest
+	}
+	if !q.Sort.Valid() {
+		return nil, nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	if q.Cursor != nil && q.Cursor.Sort != q.Sort {
+		return nil, nil, fmt.Errorf("%w: it belongs to a different sort order", ErrInvalidCursor)
+	}
+
+	// before reports whether a comes before b in the sort order
+	before := func(a, b VideoCursor) bool {
+		ids := bytes.Compare(a.ID[:], b.ID[:])
+		switch q.Sort {
+		case VideoSortOldest:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return ids < 0
+		case VideoSortTitle:
+			ta, tb := strings.ToLower(a.Title), strings.ToLower(b.Title)
+			if ta != tb {
+				return ta < tb
+			}
+			return ids < 0
+		case VideoSortMostViewed:
+			if a.ViewCount != b.ViewCount {
+				return a.ViewCount > b.ViewCount
+			}
+			return ids > 0
+		default:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.After(b.CreatedAt)
+			}
+			return ids > 0
+		}
+	}
+
+	s.mu.Lock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		switch {
+		case q.OwnerID != uuid.Nil && video.UserID != q.OwnerID,
+			q.Visibility != "" && video.Visibility != q.Visibility,
+			!q.IncludeHidden && video.Hidden,
+			q.AspectRatio != "" && (video.AspectRatio == nil || *video.AspectRatio != q.AspectRatio),
+			!q.CreatedAfter.IsZero() && video.CreatedAt.Before(q.CreatedAfter),
+			!q.CreatedBefore.IsZero() && !video.CreatedAt.Before(q.CreatedBefore),
+			q.Cursor != nil && !before(*q.Cursor, newVideoCursor(q.Sort, video)):
+			continue
+		}
+		videos = append(videos, storedVideo(video))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(videos, func(i, j int) bool {
+		return before(newVideoCursor(q.Sort, videos[i]), newVideoCursor(q.Sort, videos[j]))
+	})
+	if len(videos) <= q.Limit {
+		return videos, nil, nil
+	}
+	videos = videos[:q.Limit]
+	next := newVideoCursor(q.Sort, videos[len(videos)-1])
+	return videos, &next, nil
+}
+
+// SearchVideos matches like Client's fallback without FTS5: every term must
+// appear in the title or description, newest first.
+func (s *MemoryStore) SearchVideos(q SearchQuery) ([]SearchResult, bool, error) {
+	terms := parseSearchTerms(q.Text)
+	if len(terms) == 0 {
+		return []SearchResult{}, false, nil
+	}
+
+	s.mu.Lock()
+	matches := []Video{}
+	for _, video := range s.videos {
+		if (video.Visibility != VisibilityPublic || video.Hidden) && video.UserID != q.ViewerID {
+			continue
+		}
+		title, description := strings.ToLower(video.Title), strings.ToLower(video.Description)
+		matched := true
+		for _, term := range terms {
+			text := strings.ToLower(term.text)
+			if !strings.Contains(title, text) && !strings.Contains(description, text) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, storedVideo(video))
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.After(matches[j].CreatedAt)
+	})
+	matches = matches[min(q.Offset, len(matches)):]
+
+	results := []SearchResult{}
+	for _, video := range matches {
+		if len(results) == q.Limit {
+			return results, true, nil
+		}
+		titleHighlight, snippet := highlightTerms(video.Title, video.Description, terms)
+		results = append(results, SearchResult{
+			Video:          video,
+			TitleHighlight: markHighlights(titleHighlight),
+			Snippet:        markHighlights(snippet),
+		})
+	}
+	return results, false, nil
+}
+
+func (s *MemoryStore) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[params.Token]; ok {
+		return RefreshToken{}, errors.New("refresh token already exists")
+	}
+	if params.FamilyID == uuid.Nil {
+		params.FamilyID = uuid.New()
+	}
+	rt := RefreshToken{CreateRefreshTokenParams: params, CreatedAt: now(), UpdatedAt: now()}
+	s.tokens[rt.Token] = rt
+	return rt, nil
+}
+
+func (s *MemoryStore) GetRefreshToken(token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rt, ok := s.tokens[token]; ok {
+		revokedAt := now()
+		rt.RevokedAt = &revokedAt
+		s.tokens[token] = rt
+	}
+	return nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(token, next string, expiresAt time.Time) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	if rt.RevokedAt != nil {
+		return RefreshToken{}, ErrRefreshTokenRevoked
+	}
+	revokedAt := now()
+	rt.RevokedAt = &revokedAt
+	rt.UpdatedAt = now()
+	s.tokens[token] = rt
+
+	rotated := RefreshToken{
+		CreateRefreshTokenParams: CreateRefreshTokenParams{
+			Token:     next,
+			UserID:    rt.UserID,
+			FamilyID:  rt.FamilyID,
+			ExpiresAt: expiresAt,
+		},
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+	s.tokens[next] = rotated
+	return rotated, nil
+}
+
+func (s *MemoryStore) RevokeRefreshTokenFamily(familyID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, rt := range s.tokens {
+		if rt.FamilyID == familyID && rt.RevokedAt == nil {
+			revokedAt := now()
+			rt.RevokedAt = &revokedAt
+			rt.UpdatedAt = now()
+			s.tokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, rt := range s.tokens {
+		if rt.UserID == userID && rt.RevokedAt == nil {
+			revokedAt := now()
+			rt.RevokedAt = &revokedAt
+			rt.UpdatedAt = now()
+			s.tokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateEmailToken(params CreateEmailTokenParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.emailTokens[params.TokenHash]; ok {
+		return errors.New("email token already exists")
+	}
+	s.emailTokens[params.TokenHash] = EmailToken{CreateEmailTokenParams: params, CreatedAt: now()}
+	return nil
+}
+
+func (s *MemoryStore) ConsumeEmailToken(tokenHash string, purpose EmailTokenPurpose) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	et, ok := s.emailTokens[tokenHash]
+	if !ok || et.Purpose != purpose || et.UsedAt != nil || time.Now().After(et.ExpiresAt) {
+		return uuid.Nil, ErrNotFound
+	}
+	for hash, other := range s.emailTokens {
+		if other.UserID == et.UserID && other.Purpose == purpose && other.UsedAt == nil {
+			usedAt := now()
+			other.UsedAt = &usedAt
+			s.emailTokens[hash] = other
+		}
+	}
+	return et.UserID, nil
+}
+
+func (s *MemoryStore) SetPendingTOTPSecret(userID uuid.UUID, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	user.TOTPPendingSecret = secret
+	user.UpdatedAt = now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStore) EnableTOTP(userID uuid.UUID, secret string, step int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || secret == "" || user.TOTPPendingSecret != secret {
+		return ErrNotFound
+	}
+	enabledAt := now()
+	user.TOTPSecret = secret
+	user.TOTPPendingSecret = ""
+	user.TOTPEnabledAt = &enabledAt
+	user.UpdatedAt = now()
+	s.users[userID] = user
+	s.totpSteps[userID] = step
+	codes := map[string]bool{}
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = true
+	}
+	s.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *MemoryStore) DisableTOTP(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.TOTPSecret = ""
+		user.TOTPPendingSecret = ""
+		user.TOTPEnabledAt = nil
+		user.UpdatedAt = now()
+		s.users[userID] = user
+	}
+	delete(s.totpSteps, userID)
+	delete(s.recoveryCodes, userID)
+	for hash, challenge := range s.loginChallenges {
+		if challenge.UserID == userID {
+			delete(s.loginChallenges, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok || s.totpSteps[userID] >= step {
+		return false, nil
+	}
+	s.totpSteps[userID] = step
+	return true, nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recoveryCodes[userID][codeHash] {
+		return ErrNotFound
+	}
+	delete(s.recoveryCodes[userID], codeHash)
+	return nil
+}
+
+type loginChallenge struct {
+	CreateLoginChallengeParams
+	attempts int
+}
+
+func (s *MemoryStore) CreateLoginChallenge(params CreateLoginChallengeParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.loginChallenges[params.TokenHash]; ok {
+		return errors.New("login challenge already exists")
+	}
+	s.loginChallenges[params.TokenHash] = loginChallenge{CreateLoginChallengeParams: params}
+	return nil
+}
+
+func (s *MemoryStore) AttemptLoginChallenge(tokenHash string, maxAttempts int) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge, ok := s.loginChallenges[tokenHash]
+	if !ok || challenge.attempts >= maxAttempts || time.Now().After(challenge.ExpiresAt) {
+		return uuid.Nil, ErrNotFound
+	}
+	challenge.attempts++
+	s.loginChallenges[tokenHash] = challenge
+	return challenge.UserID, nil
+}
+
+func (s *MemoryStore) DeleteLoginChallenge(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.loginChallenges, tokenHash)
+	return nil
+}
+
+func (s *MemoryStore) DeleteRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+	return nil
+}
+
+func (s *MemoryStore) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.apiKeys {
+		if key.KeyHash == params.KeyHash {
+			return APIKey{}, errors.New("API key already exists")
+		}
+	}
+	params.Scopes = append([]APIKeyScope{}, params.Scopes...)
+	key := APIKey{ID: uuid.New(), CreatedAt: now(), UpdatedAt: now(), CreateAPIKeyParams: params}
+	s.apiKeys[key.ID] = key
+	return key, nil
+}
+
+func (s *MemoryStore) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return APIKey{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.apiKeys {
+		if key.KeyHash == keyHash {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []APIKey{}
+	for _, key := range s.apiKeys {
+		if key.UserID == userID && key.RevokedAt == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID.String() < keys[j].ID.String()
+	})
+	return keys, nil
+}
+
+func (s *MemoryStore) MarkAPIKeyUsed(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.apiKeys[id]; ok {
+		usedAt := now()
+		key.LastUsedAt = &usedAt
+		s.apiKeys[id] = key
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.apiKeys[id]; ok && key.RevokedAt == nil {
+		revokedAt := now()
+		key.RevokedAt = &revokedAt
+		key.UpdatedAt = now()
+		s.apiKeys[id] = key
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateJob(params CreateJobParams) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if params.MaxAttempts < 1 {
+		params.MaxAttempts = 1
+	}
+	job := Job{
+		ID:              uuid.New(),
+		CreatedAt:       now(),
+		UpdatedAt:       now(),
+		Status:          JobStatusPending,
+		RunAt:           time.Now().UTC(),
+		CreateJobParams: params,
+	}
+	s.jobs[job.ID] = job
+	return job, nil
+}
+
+func (s *MemoryStore) GetJob(id uuid.UUID) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return job, nil
+}
+
+func (s *MemoryStore) ClaimNextJob(leaseUntil time.Time) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next *Job
+	for _, job := range s.jobs {
+		if job.Status != JobStatusPending || job.RunAt.After(time.Now()) {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) {
+			next = &job
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	leaseUntil = leaseUntil.UTC()
+	next.Status = JobStatusProcessing
+	next.Attempts++
+	next.LeaseExpiresAt = &leaseUntil
+	next.UpdatedAt = now()
+	s.jobs[next.ID] = *next
+	return next, nil
+}
+
+// updateJob applies fn to a job if it exists. The caller holds the lock.
+func (s *MemoryStore) updateJob(id uuid.UUID, fn func(job *Job)) {
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	fn(&job)
+	job.UpdatedAt = now()
+	s.jobs[id] = job
+}
+
+func (s *MemoryStore) ExtendJobLease(id uuid.UUID, attempt int, leaseUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.Status != JobStatusProcessing || job.Attempts != attempt {
+		return ErrNotFound
+	}
+	s.updateJob(id, func(job *Job) {
+		leaseUntil = leaseUntil.UTC()
+		job.LeaseExpiresAt = &leaseUntil
+	})
+	return nil
+}
+
+func (s *MemoryStore) CompleteJob(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateJob(id, func(job *Job) {
+		completedAt := now()
+		job.Status = JobStatusCompleted
+		job.LastError = nil
+		job.CompletedAt = &completedAt
+	})
+	return nil
+}
+
+func (s *MemoryStore) RetryJob(id uuid.UUID, lastError string, runAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateJob(id, func(job *Job) {
+		job.Status = JobStatusPending
+		job.LastError = &lastError
+		job.RunAt = runAt.UTC()
+	})
+	return nil
+}
+
+func (s *MemoryStore) FailJob(id uuid.UUID, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateJob(id, func(job *Job) {
+		completedAt := now()
+		job.Status = JobStatusFailed
+		job.LastError = &lastError
+		job.CompletedAt = &completedAt
+	})
+	return nil
+}
+
+func (s *MemoryStore) RequeueExpiredJobs(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job.Status != JobStatusProcessing {
+			continue
+		}
+		if job.LeaseExpiresAt != nil && !job.LeaseExpiresAt.Before(now) {
+			continue
+		}
+		s.updateJob(id, func(job *Job) {
+			job.Status = JobStatusPending
+			job.LeaseExpiresAt = nil
+		})
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateUpload(params CreateUploadParams) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := Upload{ID: uuid.New(), CreatedAt: now(), UpdatedAt: now(), CreateUploadParams: params}
+	s.uploads[upload.ID] = upload
+	return upload, nil
+}
+
+func (s *MemoryStore) GetUpload(id uuid.UUID) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	return upload, nil
+}
+
+func (s *MemoryStore) UpdateUploadOffset(id uuid.UUID, from, to int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[id]
+	if !ok || upload.Offset != from {
+		return ErrUploadOffsetChanged
+	}
+	upload.Offset = to
+	upload.UpdatedAt = now()
+	s.uploads[id] = upload
+	return nil
+}
+
+func (s *MemoryStore) ListStaleUploads(before time.Time) ([]Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := []Upload{}
+	for _, upload := range s.uploads {
+		if upload.UpdatedAt.Before(before) {
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads, nil
+}
+
+func (s *MemoryStore) DeleteUpload(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, id)
+	return nil
+}
+
+func (s *MemoryStore) CreateDirectUpload(params CreateDirectUploadParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.directUploads[params.Key]; ok {
+		return errors.New("direct upload already exists")
+	}
+	s.directUploads[params.Key] = DirectUpload{CreateDirectUploadParams: params, CreatedAt: now()}
+	return nil
+}
+
+func (s *MemoryStore) CompleteDirectUpload(key string, videoID uuid.UUID) (DirectUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.directUploads[key]
+	if !ok || upload.VideoID != videoID || upload.CompletedAt != nil || !time.Now().Before(upload.ExpiresAt) {
+		return DirectUpload{}, ErrNotFound
+	}
+	completedAt := now()
+	upload.CompletedAt = &completedAt
+	s.directUploads[key] = upload
+	return upload, nil
+}
@@ -0,0 +1,309 @@
+package database
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MigrationsDir is where migration files live in the source tree, relative
+// to the repository root. They are embedded at build time, so new files only
+// take effect after a rebuild.
+const MigrationsDir = "internal/database/migrations"
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a numbered schema change, read from
+// migrations/<dialect>/<version>_<name>.up.sql and its .down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is nil for pending migrations.
+	AppliedAt *time.Time
+}
+
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := "migrations/" + migrationDir(d)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s/%s", dir, entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		contents, err := fs.ReadFile(migrationFiles, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(contents)
+		} else {
+			m.down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func migrationDir(d dialect) string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+func (c Client) ensureMigrationsTable() error {
+	timestamp := "TIMESTAMP"
+	if c.db.dialect == dialectPostgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + timestamp + ` NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)
+	`)
+	return err
+}
+
+func (c Client) appliedMigrations() (map[int]time.Time, error) {
+	if err := c.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := c.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every known migration in order, with when it was
+// applied.
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction, and returns the ones it applied.
+func (c Client) MigrateUp() ([]Migration, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 && c.db.dialect == dialectSQLite {
+		adopted, err := c.adoptLegacySchema(migrations)
+		if err != nil {
+			return nil, err
+		}
+		if adopted {
+			applied[migrations[0].Version] = time.Now()
+		}
+	}
+
+	done := []Migration{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := c.applyMigration(m, true); err != nil {
+			return done, err
+		}
+		log.Printf("Applied migration %s", m)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first, and
+// returns the ones it reverted.
+func (c Client) MigrateDown(steps int) ([]Migration, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := c.applyMigration(m, false); err != nil {
+			return done, err
+		}
+		log.Printf("Reverted migration %s", m)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func (c Client) applyMigration(m Migration, up bool) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, record := m.up, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)"
+	if !up {
+		script, record = m.down, "DELETE FROM schema_migrations WHERE version = ? AND name = ?"
+	}
+	// Scripts run as written, they take no placeholders to rebind
+	if _, err := tx.Tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %s: %w", m, err)
+	}
+	if _, err := tx.Exec(record, m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptLegacySchema handles SQLite databases created before versioned
+// migrations. It upgrades them to the layout of the first migration and
+// records it as applied, reporting whether there was anything to adopt.
+func (c *Client) adoptLegacySchema(migrations []Migration) (bool, error) {
+	var tables int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'videos'`).Scan(&tables)
+	if err != nil {
+		return false, err
+	}
+	if tables == 0 || len(migrations) == 0 {
+		return false, nil
+	}
+
+	err = c.upgradeLegacySchema()
+	if err != nil {
+		return false, err
+	}
+	first := migrations[0]
+	_, err = c.db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", first.Version, first.Name)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Adopted existing database as migration %s", first)
+	return true, nil
+}
+
+// CreateMigration writes empty up and down files for a new migration to
+// every dialect under dir, numbered after the highest existing version, and
+// returns their paths.
+func CreateMigration(dir, name string) ([]string, error) {
+	if !regexp.MustCompile(`^[a-z0-9_]+$`).MatchString(name) {
+		return nil, errors.New("migration names may only use lowercase letters, digits and underscores")
+	}
+
+	dialects := []dialect{dialectSQLite, dialectPostgres}
+	next := 1
+	for _, d := range dialects {
+		entries, err := os.ReadDir(filepath.Join(dir, migrationDir(d)))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			match := migrationFileRe.FindStringSubmatch(entry.Name())
+			if match == nil {
+				continue
+			}
+			if version, _ := strconv.Atoi(match[1]); version >= next {
+				next = version + 1
+			}
+		}
+	}
+
+	paths := []string{}
+	for _, d := range dialects {
+		for _, direction := range []string{"up", "down"} {
+			p := filepath.Join(dir, migrationDir(d), fmt.Sprintf("%04d_%s.%s.sql", next, name, direction))
+			contents := fmt.Sprintf("-- %s migration %04d_%s (%s)\n", direction, next, name, migrationDir(d))
+			if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+				return paths, err
+			}
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"slices"
+	"testing"
+)
+
+// forEachClient runs test against an empty, fully migrated database of
+// every SQL dialect. Postgres needs TEST_POSTGRES_DSN.
+func forEachClient(t *testing.T, test func(t *testing.T, c Client)) {
+	t.Helper()
+	backends := []struct {
+		name string
+		open func(t *testing.T) Client
+	}{
+		{"sqlite", openSQLiteClient},
+		{"postgres", openPostgresClient},
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	forEachClient(t, func(t *testing.T, c Client) {
+		migrations, err := loadMigrations(c.db.dialect)
+		if err != nil {
+			t.Fatalf("loadMigrations: %v", err)
+		}
+		checkApplied := func(want bool) {
+			t.Helper()
+			status, err := c.MigrationStatus()
+			if err != nil {
+				t.Fatalf("MigrationStatus: %v", err)
+			}
+			if len(status) != len(migrations) {
+				t.Fatalf("MigrationStatus lists %d migrations, want %d", len(status), len(migrations))
+			}
+			for _, s := range status {
+				if (s.AppliedAt != nil) != want {
+					t.Errorf("migration %04d applied = %v, want %v", s.Version, s.AppliedAt != nil, want)
+				}
+			}
+		}
+		checkApplied(true)
+
+		// Every down migration must undo its up migration
+		reverted, err := c.MigrateDown(len(migrations))
+		if err != nil {
+			t.Fatalf("MigrateDown: %v", err)
+		}
+		if len(reverted) != len(migrations) {
+			t.Errorf("reverted %d migrations, want %d", len(reverted), len(migrations))
+		}
+		checkApplied(false)
+
+		applied, err := c.MigrateUp()
+		if err != nil {
+			t.Fatalf("MigrateUp after reverting everything: %v", err)
+		}
+		if len(applied) != len(migrations) {
+			t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+		}
+		checkApplied(true)
+
+		applied, err = c.MigrateUp()
+		if err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		if len(applied) != 0 {
+			t.Errorf("MigrateUp on an up to date database applied %v", applied)
+		}
+
+		user := createTestUser(t, c, "user@example.com")
+		createTestVideo(t, c, user.ID, "after migrating", VisibilityPublic)
+	})
+}
+
+func TestMigrateDownOneStep(t *testing.T) {
+	forEachClient(t, func(t *testing.T, c Client) {
+		migrations, err := loadMigrations(c.db.dialect)
+		if err != nil {
+			t.Fatalf("loadMigrations: %v", err)
+		}
+		latest := migrations[len(migrations)-1]
+
+		reverted, err := c.MigrateDown(1)
+		if err != nil {
+			t.Fatalf("MigrateDown: %v", err)
+		}
+		if len(reverted) != 1 || reverted[0].Version != latest.Version {
+			t.Fatalf("MigrateDown(1) reverted %v, want %s", reverted, latest)
+		}
+		applied, err := c.MigrateUp()
+		if err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		if len(applied) != 1 || applied[0].Version != latest.Version {
+			t.Errorf("MigrateUp applied %v, want %s", applied, latest)
+		}
+	})
+}
+
+func TestVideoAssetsBackfill(t *testing.T) {
+	forEachClient(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c, "user@example.com")
+		video := createTestVideo(t, c, user.ID, "from before video_assets", VisibilityPublic)
+
+		// Rows written before video_assets only have their keys in the
+		// videos table
+		migrations, err := loadMigrations(c.db.dialect)
+		if err != nil {
+			t.Fatalf("loadMigrations: %v", err)
+		}
+		steps := len(migrations) - slices.IndexFunc(migrations, func(m Migration) bool { return m.Name == "video_assets" })
+		reverted, err := c.MigrateDown(steps)
+		if err != nil || len(reverted) != steps || reverted[len(reverted)-1].Name != "video_assets" {
+			t.Fatalf("MigrateDown(%d) = %v, %v, want everything down to video_assets reverted", steps, reverted, err)
+		}
+		thumbnail := &Thumbnail{Sizes: []ThumbnailSize{
+			{Name: "small", JPEGKey: "thumbnails/old/small.jpg", WebPKey: "thumbnails/old/small.webp"},
+		}}
+		_, err = c.db.Exec("UPDATE videos SET video_key = ?, thumbnail = ? WHERE id = ?", "landscape/old.mp4", thumbnail, video.ID)
+		if err != nil {
+			t.Fatalf("UPDATE videos: %v", err)
+		}
+		if _, err := c.MigrateUp(); err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+
+		for _, key := range []string{"landscape/old.mp4", "thumbnails/old/small.jpg", "thumbnails/old/small.webp"} {
+			got, err := c.GetVideoByAssetKey(key)
+			if err != nil || got.ID != video.ID {
+				t.Errorf("GetVideoByAssetKey(%q) = %s, %v, want %s", key, got.ID, err, video.ID)
+			}
+		}
+	})
+}
@@ -0,0 +1,5 @@
+DROP TABLE uploads;
+DROP TABLE jobs;
+DROP TABLE refresh_tokens;
+DROP TABLE videos;
+DROP TABLE users;
@@ -0,0 +1,74 @@
+-- Postgres only ever had the current layout. IF NOT EXISTS lets databases
+-- created before versioned migrations adopt this as version 1.
+CREATE TABLE IF NOT EXISTS users (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	thumbnail JSONB,
+	video_bucket TEXT,
+	video_key TEXT,
+	hls_manifest_key TEXT,
+	dash_manifest_key TEXT,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	processing_status TEXT NOT NULL DEFAULT 'pending',
+	visibility TEXT NOT NULL DEFAULT 'private',
+	aspect_ratio TEXT,
+	view_count BIGINT NOT NULL DEFAULT 0,
+	search TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', title), 'A') ||
+		setweight(to_tsvector('simple', description), 'B')
+	) STORED
+);
+
+CREATE INDEX IF NOT EXISTS idx_videos_visibility_created ON videos (visibility, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_created ON videos (user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_videos_search ON videos USING GIN (search);
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT,
+	run_at TIMESTAMPTZ NOT NULL,
+	completed_at TIMESTAMPTZ,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	input_key TEXT NOT NULL,
+	content_type TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs (status, run_at);
+
+CREATE TABLE IF NOT EXISTS uploads (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	upload_length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL,
+	metadata TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,2 @@
+-- The Postgres schema was created with the right column types, this only
+-- keeps the version numbers in step with SQLite.
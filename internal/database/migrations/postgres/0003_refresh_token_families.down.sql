@@ -0,0 +1,2 @@
+DROP INDEX idx_refresh_tokens_family;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
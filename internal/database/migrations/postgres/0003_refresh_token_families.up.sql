@@ -0,0 +1,8 @@
+-- Refresh tokens are rotated on every use. Tokens issued from one login share
+-- a family, so a reused token can revoke the whole session. Existing tokens
+-- each start a family of their own.
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID;
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family_id);
@@ -0,0 +1,16 @@
+-- API keys let scripts authenticate without a password. Only a SHA-256 hash
+-- of each key is stored; the prefix is kept so users can tell keys apart.
+CREATE TABLE api_keys (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT UNIQUE NOT NULL,
+	scopes TEXT NOT NULL,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys (user_id, created_at);
@@ -0,0 +1,16 @@
+-- Emailed tokens verify addresses and reset passwords. Like API keys they
+-- are stored hashed. Existing accounts count as verified, so they can keep
+-- uploading.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+UPDATE users SET email_verified_at = created_at;
+
+CREATE TABLE email_tokens (
+	token_hash TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	purpose TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_email_tokens_user ON email_tokens (user_id, purpose);
@@ -0,0 +1,25 @@
+-- Optional TOTP two-factor authentication. A secret waits in
+-- totp_pending_secret until the user confirms it with a code. totp_last_step
+-- is the last time step a code was accepted for, so codes can't be replayed.
+ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_pending_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	used_at TIMESTAMPTZ,
+	PRIMARY KEY (user_id, code_hash)
+);
+
+-- A login challenge is handed out after the password check and traded for
+-- tokens with a TOTP or recovery code.
+CREATE TABLE login_challenges (
+	token_hash TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,5 @@
+UPDATE api_keys SET scopes = CASE
+	WHEN scopes LIKE '%videos:read%' AND (scopes LIKE '%videos:upload%' OR scopes LIKE '%videos:write%') THEN 'read,write'
+	WHEN scopes LIKE '%videos:read%' THEN 'read'
+	ELSE 'write'
+END;
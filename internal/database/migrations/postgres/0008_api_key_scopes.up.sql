@@ -0,0 +1,4 @@
+-- API key scopes now name what they grant. Old read keys become videos:read,
+-- and old write keys videos:upload and videos:write.
+UPDATE api_keys SET scopes = REPLACE(scopes, 'write', 'videos:upload,videos:write');
+UPDATE api_keys SET scopes = REPLACE(scopes, 'read', 'videos:read');
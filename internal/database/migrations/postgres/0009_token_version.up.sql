@@ -0,0 +1,3 @@
+-- Access tokens carry the user's token version, which changing the password
+-- bumps, so a password reset revokes them.
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,24 @@
+-- Maps each stored object to the video it belongs to, so /assets can check
+-- the video's visibility without scanning every row's thumbnail JSON.
+-- Streams aren't listed, their keys start with the video ID.
+CREATE TABLE video_assets (
+	key TEXT PRIMARY KEY,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_video_assets_video ON video_assets (video_id);
+
+INSERT INTO video_assets (key, video_id)
+SELECT video_key, id FROM videos
+WHERE video_key IS NOT NULL AND video_key <> ''
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO video_assets (key, video_id)
+SELECT asset.key, videos.id
+FROM videos
+CROSS JOIN LATERAL jsonb_array_elements(
+	CASE WHEN jsonb_typeof(videos.thumbnail->'sizes') = 'array' THEN videos.thumbnail->'sizes' END
+) AS size
+CROSS JOIN LATERAL (VALUES (size->>'jpeg_key'), (size->>'webp_key')) AS asset(key)
+WHERE asset.key IS NOT NULL AND asset.key <> ''
+ON CONFLICT (key) DO NOTHING;
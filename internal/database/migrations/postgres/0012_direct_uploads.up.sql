@@ -0,0 +1,13 @@
+-- Keys handed out for direct uploads. Completing one uses it up, so each
+-- upload is queued for processing once, and only under the key and upload
+-- ID it was issued with.
+CREATE TABLE direct_uploads (
+	key TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	upload_id TEXT NOT NULL DEFAULT '',
+	size BIGINT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	completed_at TIMESTAMPTZ
+);
@@ -0,0 +1,78 @@
+-- The schema as the unversioned autoMigrate left it. Databases from before
+-- versioned migrations are upgraded to this layout in Go and then recorded
+-- as version 1 instead of running this file.
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	thumbnail TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	processing_status TEXT NOT NULL DEFAULT 'pending',
+	hls_manifest_url TEXT,
+	dash_manifest_url TEXT,
+	video_key TEXT,
+	hls_manifest_key TEXT,
+	dash_manifest_key TEXT,
+	video_bucket TEXT,
+	visibility TEXT NOT NULL DEFAULT 'private',
+	aspect_ratio TEXT,
+	view_count INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_videos_visibility_created ON videos (visibility, created_at, id);
+CREATE INDEX idx_videos_user_created ON videos (user_id, created_at, id);
+
+CREATE TABLE jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error TEXT,
+	run_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	input_key TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	upload_length INTEGER NOT NULL,
+	upload_offset INTEGER NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL,
+	metadata TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
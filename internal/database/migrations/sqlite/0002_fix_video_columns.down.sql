@@ -0,0 +1,39 @@
+CREATE TABLE videos_old (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	thumbnail TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	processing_status TEXT NOT NULL DEFAULT 'pending',
+	hls_manifest_url TEXT,
+	dash_manifest_url TEXT,
+	video_key TEXT,
+	hls_manifest_key TEXT,
+	dash_manifest_key TEXT,
+	video_bucket TEXT,
+	visibility TEXT NOT NULL DEFAULT 'private',
+	aspect_ratio TEXT,
+	view_count INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_old (
+	id, created_at, updated_at, title, description, thumbnail,
+	video_bucket, video_key, hls_manifest_key, dash_manifest_key,
+	user_id, processing_status, visibility, aspect_ratio, view_count
+)
+SELECT
+	id, created_at, updated_at, title, description, thumbnail,
+	video_bucket, video_key, hls_manifest_key, dash_manifest_key,
+	user_id, processing_status, visibility, aspect_ratio, view_count
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_old RENAME TO videos;
+
+CREATE INDEX idx_videos_visibility_created ON videos (visibility, created_at, id);
+CREATE INDEX idx_videos_user_created ON videos (user_id, created_at, id);
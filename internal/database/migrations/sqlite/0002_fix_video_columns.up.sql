@@ -0,0 +1,39 @@
+-- video_url was declared as TEXT TEXT and user_id as an INTEGER referencing
+-- the TEXT users.id. SQLite can't change column types, so the table is
+-- rebuilt. The *_url columns were replaced by storage keys and are dropped,
+-- and description gets the same empty default as on Postgres.
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	thumbnail TEXT,
+	video_bucket TEXT,
+	video_key TEXT,
+	hls_manifest_key TEXT,
+	dash_manifest_key TEXT,
+	user_id TEXT NOT NULL,
+	processing_status TEXT NOT NULL DEFAULT 'pending',
+	visibility TEXT NOT NULL DEFAULT 'private',
+	aspect_ratio TEXT,
+	view_count INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_new (
+	id, created_at, updated_at, title, description, thumbnail,
+	video_bucket, video_key, hls_manifest_key, dash_manifest_key,
+	user_id, processing_status, visibility, aspect_ratio, view_count
+)
+SELECT
+	id, created_at, updated_at, title, COALESCE(description, ''), thumbnail,
+	video_bucket, video_key, hls_manifest_key, dash_manifest_key,
+	CAST(user_id AS TEXT), processing_status, visibility, aspect_ratio, view_count
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
+
+CREATE INDEX idx_videos_visibility_created ON videos (visibility, created_at, id);
+CREATE INDEX idx_videos_user_created ON videos (user_id, created_at, id);
@@ -0,0 +1,30 @@
+-- Refresh tokens are rotated on every use. Tokens issued from one login share
+-- a family, so a reused token can revoke the whole session. Existing tokens
+-- each start a family of their own, with a random version 4 UUID.
+CREATE TABLE refresh_tokens_new (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	family_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO refresh_tokens_new (token, created_at, updated_at, revoked_at, user_id, family_id, expires_at)
+SELECT
+	token, created_at, updated_at, revoked_at, user_id,
+	lower(
+		hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+		substr(hex(randomblob(2)), 2) || '-' ||
+		substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' ||
+		hex(randomblob(6))
+	),
+	expires_at
+FROM refresh_tokens;
+
+DROP TABLE refresh_tokens;
+ALTER TABLE refresh_tokens_new RENAME TO refresh_tokens;
+
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family_id);
@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN hidden;
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,5 @@
+-- Everyone could upload before roles existed, so existing users are creators.
+-- Moderators hide videos with a flag of their own, which owners can't undo by
+-- changing the visibility.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'creator';
+ALTER TABLE videos ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT 0;
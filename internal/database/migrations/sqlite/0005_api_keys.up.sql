@@ -0,0 +1,17 @@
+-- API keys let scripts authenticate without a password. Only a SHA-256 hash
+-- of each key is stored; the prefix is kept so users can tell keys apart.
+CREATE TABLE api_keys (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT UNIQUE NOT NULL,
+	scopes TEXT NOT NULL,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys (user_id, created_at);
@@ -0,0 +1,17 @@
+-- Emailed tokens verify addresses and reset passwords. Like API keys they
+-- are stored hashed. Existing accounts count as verified, so they can keep
+-- uploading.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+UPDATE users SET email_verified_at = COALESCE(created_at, CURRENT_TIMESTAMP);
+
+CREATE TABLE email_tokens (
+	token_hash TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	purpose TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_email_tokens_user ON email_tokens (user_id, purpose);
@@ -0,0 +1,27 @@
+-- Optional TOTP two-factor authentication. A secret waits in
+-- totp_pending_secret until the user confirms it with a code. totp_last_step
+-- is the last time step a code was accepted for, so codes can't be replayed.
+ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_pending_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+	user_id TEXT NOT NULL,
+	code_hash TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	used_at TIMESTAMP,
+	PRIMARY KEY (user_id, code_hash),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- A login challenge is handed out after the password check and traded for
+-- tokens with a TOTP or recovery code.
+CREATE TABLE login_challenges (
+	token_hash TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
@@ -0,0 +1,4 @@
+-- A worker holds a lease on the job it's running and keeps extending it.
+-- Jobs whose lease ran out belonged to a worker that stopped, and are
+-- requeued.
+ALTER TABLE jobs ADD COLUMN lease_expires_at TIMESTAMP;
@@ -0,0 +1,25 @@
+-- Maps each stored object to the video it belongs to, so /assets can check
+-- the video's visibility without scanning every row's thumbnail JSON.
+-- Streams aren't listed, their keys start with the video ID.
+CREATE TABLE video_assets (
+	key TEXT PRIMARY KEY,
+	video_id TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+
+CREATE INDEX idx_video_assets_video ON video_assets (video_id);
+
+INSERT OR IGNORE INTO video_assets (key, video_id)
+SELECT video_key, id FROM videos
+WHERE video_key IS NOT NULL AND video_key <> '';
+
+INSERT OR IGNORE INTO video_assets (key, video_id)
+SELECT asset.key, asset.video_id
+FROM (
+	SELECT json_extract(size.value, '$.jpeg_key') AS key, videos.id AS video_id
+	FROM videos, json_each(CASE WHEN json_valid(videos.thumbnail) THEN videos.thumbnail END, '$.sizes') AS size
+	UNION ALL
+	SELECT json_extract(size.value, '$.webp_key'), videos.id
+	FROM videos, json_each(CASE WHEN json_valid(videos.thumbnail) THEN videos.thumbnail END, '$.sizes') AS size
+) AS asset
+WHERE asset.key IS NOT NULL AND asset.key <> '';
@@ -0,0 +1,15 @@
+-- Keys handed out for direct uploads. Completing one uses it up, so each
+-- upload is queued for processing once, and only under the key and upload
+-- ID it was issued with.
+CREATE TABLE direct_uploads (
+	key TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	upload_id TEXT NOT NULL DEFAULT '',
+	size INTEGER NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
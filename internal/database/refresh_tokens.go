@@ -2,11 +2,16 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrRefreshTokenRevoked is returned when rotating a refresh token that was
+// already revoked, which means it is being reused.
+var ErrRefreshTokenRevoked = errors.New("refresh token already revoked")
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
 	CreatedAt time.Time  `json:"created_at"`
@@ -14,23 +19,31 @@ type RefreshToken struct {
 	RevokedAt *time.Time `json:"revoked_at"`
 }
 
+// CreateRefreshTokenParams describes a new refresh token. Every token
+// rotated from the same login shares a FamilyID; leave it unset to start a
+// new family.
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
+	FamilyID  uuid.UUID `json:"family_id"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	if params.FamilyID == uuid.Nil {
+		params.FamilyID = uuid.New()
+	}
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
 			user_id,
+			family_id,
 			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.FamilyID.String(), params.ExpiresAt)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -38,6 +51,63 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken revokes token and issues next in its family in one
+// transaction, so a token can only be rotated once even when it is presented
+// twice at the same moment.
+func (c Client) RotateRefreshToken(token, next string, expiresAt time.Time) (RefreshToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
+	`, token)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if revoked == 0 {
+		var exists int
+		err = tx.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE token = ?", token).Scan(&exists)
+		if err != nil {
+			return RefreshToken{}, err
+		}
+		if exists == 0 {
+			return RefreshToken{}, ErrNotFound
+		}
+		return RefreshToken{}, ErrRefreshTokenRevoked
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			user_id,
+			family_id,
+			expires_at
+		)
+		SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, user_id, family_id, ?
+		FROM refresh_tokens
+		WHERE token = ?
+	`, next, expiresAt, token)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(next)
+}
+
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
@@ -48,19 +118,41 @@ func (c Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+// RevokeRefreshTokenFamily revokes every token rotated from the same login.
+func (c Client) RevokeRefreshTokenFamily(familyID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, familyID.String())
+	return err
+}
+
+// RevokeUserRefreshTokens logs a user out everywhere.
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, family_id, expires_at, revoked_at
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
-	var userID string
+	var userID, familyID string
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &familyID, &rt.ExpiresAt, &rt.RevokedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return RefreshToken{}, nil
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, ErrNotFound
 		}
 		return RefreshToken{}, err
 	}
@@ -69,6 +161,10 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	rt.FamilyID, err = uuid.Parse(familyID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
 
 	return rt, nil
 }
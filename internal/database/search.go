@@ -0,0 +1,325 @@
+package database
+
+import (
+	"database/sql"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// FTS5 is only compiled into go-sqlite3 with the sqlite_fts5 build tag.
+// Without it search falls back to substring matching, without ranking.
+// Postgres uses its own full-text search instead, see searchPostgres.
+func (c *Client) migrateSearch() error {
+	err := c.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&c.fts5)
+	if err != nil {
+		return err
+	}
+	if !c.fts5 {
+		log.Print("SQLite was built without FTS5, search results won't be ranked. Build with -tags sqlite_fts5 to enable it.")
+		// The triggers would make every write to videos fail without the
+		// module. The index is rebuilt once FTS5 is back.
+		_, err = c.db.Exec(`
+		DROP TRIGGER IF EXISTS videos_fts_insert;
+		DROP TRIGGER IF EXISTS videos_fts_update;
+		DROP TRIGGER IF EXISTS videos_fts_delete;
+		`)
+		return err
+	}
+
+	var exists int
+	err = c.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'videos_fts_insert'`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(
+		video_id UNINDEXED,
+		title,
+		description,
+		tokenize = 'unicode61 remove_diacritics 2'
+	)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if exists > 0 {
+		return nil
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	CREATE TRIGGER videos_fts_insert AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts (video_id, title, description)
+		VALUES (new.id, new.title, new.description);
+	END;
+	CREATE TRIGGER videos_fts_update AFTER UPDATE OF title, description ON videos BEGIN
+		UPDATE videos_fts
+		SET title = new.title, description = new.description
+		WHERE video_id = old.id;
+	END;
+	CREATE TRIGGER videos_fts_delete AFTER DELETE ON videos BEGIN
+		DELETE FROM videos_fts WHERE video_id = old.id;
+	END;
+	DELETE FROM videos_fts;
+	INSERT INTO videos_fts (video_id, title, description)
+	SELECT id, title, description FROM videos;
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type SearchQuery struct {
+	Text string
+	// ViewerID also matches the viewer's own videos, whatever their
+	// visibility. uuid.Nil only matches public videos that aren't hidden.
+	ViewerID uuid.UUID
+	Limit    int
+	Offset   int
+}
+
+// SearchResult is a matching video. TitleHighlight and Snippet are HTML
+// escaped, with the matched terms wrapped in <mark> tags.
+type SearchResult struct {
+	Video
+	TitleHighlight string `json:"title_highlight"`
+	Snippet        string `json:"snippet"`
+}
+
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// parseSearchTerms splits a user query into terms. "Quoted text" is a phrase
+// and a trailing * makes a word match as a prefix. Every other character is
+// taken literally, so users can't write invalid FTS5 syntax.
+func parseSearchTerms(q string) []searchTerm {
+	terms := []searchTerm{}
+	for len(q) > 0 {
+		q = strings.TrimLeft(q, " \t\r\n")
+		if q == "" {
+			break
+		}
+		if q[0] == '"' {
+			phrase, rest, _ := strings.Cut(q[1:], `"`)
+			q = rest
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				terms = append(terms, searchTerm{text: phrase})
+			}
+			continue
+		}
+		end := strings.IndexAny(q, " \t\r\n\"")
+		if end < 0 {
+			end = len(q)
+		}
+		word := q[:end]
+		q = q[end:]
+		term := searchTerm{text: strings.TrimRight(word, "*")}
+		term.prefix = len(term.text) < len(word)
+		if term.text != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func ftsQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		part := `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"`
+		if term.prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// SearchVideos matches the query against titles and descriptions. It
+// returns one extra result past Limit when there are more, like a cursor.
+func (c Client) SearchVideos(q SearchQuery) ([]SearchResult, bool, error) {
+	terms := parseSearchTerms(q.Text)
+	if len(terms) == 0 {
+		return []SearchResult{}, false, nil
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	switch {
+	case c.db.dialect == dialectPostgres:
+		if tsQuery(terms) == "" {
+			// Only punctuation, which can't match anything
+			return []SearchResult{}, false, nil
+		}
+		rows, err = c.searchPostgres(terms, q)
+	case c.fts5:
+		rows, err = c.db.Query(`
+		SELECT`+videoColumns+`,
+			matches.title_highlight,
+			matches.snippet
+		FROM videos
+		JOIN (
+			SELECT
+				video_id,
+				highlight(videos_fts, 1, char(2), char(3)) AS title_highlight,
+				snippet(videos_fts, 2, char(2), char(3), '…', 16) AS snippet,
+				bm25(videos_fts, 0.0, 10.0, 1.0) AS rank
+			FROM videos_fts
+			WHERE videos_fts MATCH ?
+		) AS matches ON matches.video_id = videos.id
+		WHERE (visibility = ? AND NOT hidden) OR user_id = ?
+		ORDER BY matches.rank, created_at DESC
+		LIMIT ? OFFSET ?
+		`, ftsQuery(terms), VisibilityPublic, q.ViewerID, q.Limit+1, q.Offset)
+	default:
+		rows, err = c.searchWithoutFTS(terms, q)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		var titleHighlight, snippet string
+		if c.fts5 && c.db.dialect == dialectSQLite {
+			result.Video, err = scanVideo(extraScanner{rows, []any{&titleHighlight, &snippet}})
+		} else {
+			result.Video, err = scanVideo(rows)
+			titleHighlight, snippet = highlightTerms(result.Title, result.Description, terms)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		result.TitleHighlight = markHighlights(titleHighlight)
+		result.Snippet = markHighlights(snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(results) <= q.Limit {
+		return results, false, nil
+	}
+	return results[:q.Limit], true, nil
+}
+
+func (c Client) searchWithoutFTS(terms []searchTerm, q SearchQuery) (*sql.Rows, error) {
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	where := []string{}
+	args := []any{}
+	for _, term := range terms {
+		pattern := "%" + escape.Replace(term.text) + "%"
+		where = append(where, `(title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	args = append(args, VisibilityPublic, q.ViewerID, q.Limit+1, q.Offset)
+	return c.db.Query(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE `+strings.Join(where, " AND ")+`
+		AND ((visibility = ? AND NOT hidden) OR user_id = ?)
+	ORDER BY created_at DESC
+	LIMIT ? OFFSET ?
+	`, args...)
+}
+
+// tsQuery builds a Postgres tsquery matching every term. Terms are split
+// into words the way the 'simple' text search configuration splits them.
+func tsQuery(terms []searchTerm) string {
+	parts := []string{}
+	for _, term := range terms {
+		words := strings.FieldsFunc(strings.ToLower(term.text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(words) == 0 {
+			continue
+		}
+		if term.prefix {
+			words[len(words)-1] += ":*"
+		}
+		parts = append(parts, "("+strings.Join(words, " <-> ")+")")
+	}
+	return strings.Join(parts, " & ")
+}
+
+// searchPostgres ranks matches on the generated search column, which weighs
+// titles above descriptions. Snippets are highlighted like the fallback.
+func (c Client) searchPostgres(terms []searchTerm, q SearchQuery) (*sql.Rows, error) {
+	return c.db.Query(`
+	SELECT`+videoColumns+`
+	FROM videos, to_tsquery('simple', ?) AS query
+	WHERE search @@ query
+		AND ((visibility = ? AND NOT hidden) OR user_id = ?)
+	ORDER BY ts_rank(search, query) DESC, created_at DESC
+	LIMIT ? OFFSET ?
+	`, tsQuery(terms), VisibilityPublic, q.ViewerID, q.Limit+1, q.Offset)
+}
+
+// extraScanner scans a video followed by extra columns.
+type extraScanner struct {
+	row   interface{ Scan(...any) error }
+	extra []any
+}
+
+func (s extraScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// highlightTerms mimics FTS5's highlight and snippet functions for the
+// fallback search.
+func highlightTerms(title, description string, terms []searchTerm) (string, string) {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(term.text))
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	mark := "\x02$0\x03"
+
+	snippet := description
+	const window = 80
+	if loc := re.FindStringIndex(description); loc != nil && len(description) > 2*window {
+		start, end := max(0, loc[0]-window), min(len(description), loc[1]+window)
+		for start > 0 && !utf8.RuneStart(description[start]) {
+			start--
+		}
+		for end < len(description) && !utf8.RuneStart(description[end]) {
+			end++
+		}
+		snippet = description[start:end]
+		if start > 0 {
+			snippet = "…" + snippet
+		}
+		if end < len(description) {
+			snippet += "…"
+		}
+	}
+	return re.ReplaceAllString(title, mark), re.ReplaceAllString(snippet, mark)
+}
+
+// markHighlights escapes user text for HTML and only then turns the \x02 and
+// \x03 markers around matches into <mark> tags.
+func markHighlights(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, "\x02", "<mark>")
+	return strings.ReplaceAll(s, "\x03", "</mark>")
+}
@@ -0,0 +1,733 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The tests in this file are a contract every Store implementation has to
+// meet, so MemoryStore keeps behaving like the SQL Client it stands in for.
+// They run against Postgres as well when TEST_POSTGRES_DSN is set.
+
+func TestMain(m *testing.M) {
+	// Migrations log every step for every store the tests open
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type storeBackend struct {
+	name string
+	open func(t *testing.T) Store
+}
+
+var storeBackends = []storeBackend{
+	{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+	{"sqlite", func(t *testing.T) Store { return openSQLiteClient(t) }},
+	{"postgres", func(t *testing.T) Store { return openPostgresClient(t) }},
+}
+
+func openSQLiteClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// openPostgresClient connects to TEST_POSTGRES_DSN, skipping the test when
+// it isn't set. Each test gets a schema of its own, dropped afterwards, so
+// it starts from an empty database and tests don't see each other's rows.
+func openPostgresClient(t *testing.T) Client {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN isn't set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	schema := "tubely_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatalf("CREATE SCHEMA: %v", err)
+	}
+	t.Cleanup(func() {
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Errorf("DROP SCHEMA: %v", err)
+		}
+		admin.Close()
+	})
+
+	c, err := NewClient(withSearchPath(t, dsn, schema))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// withSearchPath adds a search_path run-time parameter to a connection
+// string in either URL or key=value form.
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	t.Helper()
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("TEST_POSTGRES_DSN: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// forEachStore runs test against an empty store of every backend.
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Helper()
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+func createTestUser(t *testing.T, s Store, email string) *User {
+	t.Helper()
+	user, err := s.CreateUser(CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func createTestVideo(t *testing.T, s Store, userID uuid.UUID, title string, visibility Visibility) Video {
+	t.Helper()
+	video, err := s.CreateVideo(CreateVideoParams{Title: title, UserID: userID, Visibility: visibility})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+func videoIDs(videos []Video) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(videos))
+	for _, video := range videos {
+		ids = append(ids, video.ID)
+	}
+	return ids
+}
+
+// sameVideos reports whether got and want hold the same videos, in any
+// order.
+func sameVideos(got []Video, want ...Video) bool {
+	a, b := videoIDs(got), videoIDs(want)
+	compare := func(x, y uuid.UUID) int { return bytes.Compare(x[:], y[:]) }
+	slices.SortFunc(a, compare)
+	slices.SortFunc(b, compare)
+	return slices.Equal(a, b)
+}
+
+// videoBefore reports whether a must be listed before b in the sort order.
+func videoBefore(sort VideoSort, a, b Video) bool {
+	ids := bytes.Compare(a.ID[:], b.ID[:])
+	switch sort {
+	case VideoSortOldest:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return ids < 0
+	case VideoSortTitle:
+		if ta, tb := strings.ToLower(a.Title), strings.ToLower(b.Title); ta != tb {
+			return ta < tb
+		}
+		return ids < 0
+	case VideoSortMostViewed:
+		if a.ViewCount != b.ViewCount {
+			return a.ViewCount > b.ViewCount
+		}
+		return ids > 0
+	default:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return ids > 0
+	}
+}
+
+func TestQueryVideosCursorPagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "owner@example.com")
+		titles := []string{"alpha", "Bravo", "alpha", "charlie", "Delta", "bravo", "echo"}
+		for i, title := range titles {
+			video := createTestVideo(t, s, user.ID, title, VisibilityPublic)
+			for range i % 3 {
+				if err := s.IncrementVideoViews(video.ID); err != nil {
+					t.Fatalf("IncrementVideoViews: %v", err)
+				}
+			}
+		}
+
+		for _, sort := range []VideoSort{VideoSortNewest, VideoSortOldest, VideoSortTitle, VideoSortMostViewed} {
+			t.Run(string(sort), func(t *testing.T) {
+				all, next, err := s.QueryVideos(VideoQuery{Sort: sort, Limit: 100})
+				if err != nil {
+					t.Fatalf("QueryVideos: %v", err)
+				}
+				if next != nil {
+					t.Errorf("got a next cursor for a page holding everything")
+				}
+				if len(all) != len(titles) {
+					t.Fatalf("got %d videos, want %d", len(all), len(titles))
+				}
+				for i := 1; i < len(all); i++ {
+					if !videoBefore(sort, all[i-1], all[i]) {
+						t.Errorf("%q (%s) is listed before %q (%s)", all[i-1].Title, all[i-1].ID, all[i].Title, all[i].ID)
+					}
+				}
+
+				// Paging through must give the same videos in the same order
+				paged := []Video{}
+				var cursor *VideoCursor
+				for pages := 0; ; pages++ {
+					if pages > len(titles) {
+						t.Fatal("pagination doesn't end")
+					}
+					page, next, err := s.QueryVideos(VideoQuery{Sort: sort, Limit: 3, Cursor: cursor})
+					if err != nil {
+						t.Fatalf("QueryVideos: %v", err)
+					}
+					paged = append(paged, page...)
+					if next == nil {
+						break
+					}
+					// Cursors go through clients as opaque strings
+					parsed, err := ParseVideoCursor(next.Encode())
+					if err != nil {
+						t.Fatalf("ParseVideoCursor: %v", err)
+					}
+					cursor = &parsed
+				}
+				if !slices.Equal(videoIDs(paged), videoIDs(all)) {
+					t.Errorf("paged listing differs from the full one")
+				}
+			})
+		}
+
+		cursor := VideoCursor{Sort: VideoSortTitle, Title: "alpha", ID: uuid.New()}
+		_, _, err := s.QueryVideos(VideoQuery{Sort: VideoSortNewest, Limit: 3, Cursor: &cursor})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor from another sort order: got %v, want %v", err, ErrInvalidCursor)
+		}
+	})
+}
+
+func TestVideoVisibilityAndHiddenFilters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		alice := createTestUser(t, s, "alice@example.com")
+		bob := createTestUser(t, s, "bob@example.com")
+
+		public := createTestVideo(t, s, alice.ID, "public cat", VisibilityPublic)
+		unlisted := createTestVideo(t, s, alice.ID, "unlisted cat", VisibilityUnlisted)
+		private := createTestVideo(t, s, alice.ID, "private cat", VisibilityPrivate)
+		hidden := createTestVideo(t, s, alice.ID, "hidden cat", VisibilityPublic)
+		bobs := createTestVideo(t, s, bob.ID, "bob's cat", VisibilityPublic)
+
+		if err := s.SetVideoHidden(hidden.ID, true); err != nil {
+			t.Fatalf("SetVideoHidden: %v", err)
+		}
+		got, err := s.GetVideo(hidden.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if !got.Hidden {
+			t.Errorf("hidden video isn't marked hidden")
+		}
+
+		queries := []struct {
+			name string
+			q    VideoQuery
+			want []Video
+		}{
+			{"no filter", VideoQuery{}, []Video{public, unlisted, private, bobs}},
+			{"public", VideoQuery{Visibility: VisibilityPublic}, []Video{public, bobs}},
+			{"private", VideoQuery{Visibility: VisibilityPrivate}, []Video{private}},
+			{"owner", VideoQuery{OwnerID: bob.ID}, []Video{bobs}},
+			{"owner with hidden", VideoQuery{OwnerID: alice.ID, IncludeHidden: true}, []Video{public, unlisted, private, hidden}},
+			{"public with hidden", VideoQuery{Visibility: VisibilityPublic, IncludeHidden: true}, []Video{public, hidden, bobs}},
+		}
+		for _, tt := range queries {
+			tt.q.Limit = 100
+			videos, _, err := s.QueryVideos(tt.q)
+			if err != nil {
+				t.Fatalf("%s: QueryVideos: %v", tt.name, err)
+			}
+			if !sameVideos(videos, tt.want...) {
+				t.Errorf("%s: got %v, want %v", tt.name, videoIDs(videos), videoIDs(tt.want))
+			}
+		}
+
+		searches := []struct {
+			name   string
+			viewer uuid.UUID
+			want   []Video
+		}{
+			{"anonymous", uuid.Nil, []Video{public, bobs}},
+			{"other user", bob.ID, []Video{public, bobs}},
+			{"owner", alice.ID, []Video{public, unlisted, private, hidden, bobs}},
+		}
+		for _, tt := range searches {
+			results, _, err := s.SearchVideos(SearchQuery{Text: "cat", ViewerID: tt.viewer, Limit: 100})
+			if err != nil {
+				t.Fatalf("%s: SearchVideos: %v", tt.name, err)
+			}
+			videos := []Video{}
+			for _, result := range results {
+				videos = append(videos, result.Video)
+			}
+			if !sameVideos(videos, tt.want...) {
+				t.Errorf("%s search: got %v, want %v", tt.name, videoIDs(videos), videoIDs(tt.want))
+			}
+		}
+
+		if err := s.SetVideoHidden(hidden.ID, false); err != nil {
+			t.Fatalf("SetVideoHidden: %v", err)
+		}
+		videos, _, err := s.QueryVideos(VideoQuery{Visibility: VisibilityPublic, Limit: 100})
+		if err != nil {
+			t.Fatalf("QueryVideos: %v", err)
+		}
+		if !sameVideos(videos, public, hidden, bobs) {
+			t.Errorf("unhidden video isn't listed again: got %v", videoIDs(videos))
+		}
+	})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+		expires := time.Now().Add(time.Hour).UTC()
+
+		first, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "first", UserID: user.ID, ExpiresAt: expires})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+		if first.FamilyID == uuid.Nil {
+			t.Fatal("new token didn't get a family")
+		}
+
+		second, err := s.RotateRefreshToken("first", "second", expires)
+		if err != nil {
+			t.Fatalf("RotateRefreshToken: %v", err)
+		}
+		if second.Token != "second" || second.UserID != user.ID || second.FamilyID != first.FamilyID {
+			t.Errorf("rotated token = %+v, want token second for the same user and family", second)
+		}
+		if second.RevokedAt != nil {
+			t.Error("rotated token is already revoked")
+		}
+
+		old, err := s.GetRefreshToken("first")
+		if err != nil {
+			t.Fatalf("GetRefreshToken: %v", err)
+		}
+		if old.RevokedAt == nil {
+			t.Error("rotating didn't revoke the old token")
+		}
+
+		_, err = s.RotateRefreshToken("first", "third", expires)
+		if !errors.Is(err, ErrRefreshTokenRevoked) {
+			t.Errorf("rotating a revoked token: got %v, want %v", err, ErrRefreshTokenRevoked)
+		}
+		if _, err := s.GetRefreshToken("third"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("reusing a revoked token issued a new one: %v", err)
+		}
+		_, err = s.RotateRefreshToken("unknown", "fourth", expires)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("rotating an unknown token: got %v, want %v", err, ErrNotFound)
+		}
+
+		// A replayed token revokes its whole family, as handlerRefresh does
+		other, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "other", UserID: user.ID, ExpiresAt: expires})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+		if err := s.RevokeRefreshTokenFamily(first.FamilyID); err != nil {
+			t.Fatalf("RevokeRefreshTokenFamily: %v", err)
+		}
+		second, err = s.GetRefreshToken("second")
+		if err != nil {
+			t.Fatalf("GetRefreshToken: %v", err)
+		}
+		if second.RevokedAt == nil {
+			t.Error("revoking the family didn't revoke its latest token")
+		}
+		other, err = s.GetRefreshToken(other.Token)
+		if err != nil {
+			t.Fatalf("GetRefreshToken: %v", err)
+		}
+		if other.RevokedAt != nil {
+			t.Error("revoking a family revoked a token from another login")
+		}
+	})
+}
+
+func TestTOTPStepReplay(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+
+		if err := s.SetPendingTOTPSecret(user.ID, "SECRET1"); err != nil {
+			t.Fatalf("SetPendingTOTPSecret: %v", err)
+		}
+		err := s.EnableTOTP(user.ID, "OTHER", 100, nil)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("enabling a secret that isn't pending: got %v, want %v", err, ErrNotFound)
+		}
+		if err := s.EnableTOTP(user.ID, "SECRET1", 100, []string{"code1", "code2"}); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+		got, err := s.GetUser(user.ID)
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if got.TOTPSecret != "SECRET1" || got.TOTPPendingSecret != "" || got.TOTPEnabledAt == nil {
+			t.Errorf("after EnableTOTP: secret %q, pending %q, enabled at %v", got.TOTPSecret, got.TOTPPendingSecret, got.TOTPEnabledAt)
+		}
+
+		steps := []struct {
+			step int64
+			want bool
+		}{
+			{100, false}, // the code that confirmed enrolment
+			{99, false},
+			{101, true},
+			{101, false},
+			{100, false},
+			{103, true},
+		}
+		for _, tt := range steps {
+			ok, err := s.UseTOTPStep(user.ID, tt.step)
+			if err != nil {
+				t.Fatalf("UseTOTPStep(%d): %v", tt.step, err)
+			}
+			if ok != tt.want {
+				t.Errorf("UseTOTPStep(%d) = %v, want %v", tt.step, ok, tt.want)
+			}
+		}
+
+		if err := s.UseRecoveryCode(user.ID, "code1"); err != nil {
+			t.Errorf("UseRecoveryCode: %v", err)
+		}
+		if err := s.UseRecoveryCode(user.ID, "code1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("reusing a recovery code: got %v, want %v", err, ErrNotFound)
+		}
+		if err := s.UseRecoveryCode(user.ID, "unknown"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown recovery code: got %v, want %v", err, ErrNotFound)
+		}
+
+		// Turning it off and on again starts from the new confirming step
+		if err := s.DisableTOTP(user.ID); err != nil {
+			t.Fatalf("DisableTOTP: %v", err)
+		}
+		if err := s.UseRecoveryCode(user.ID, "code2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("recovery code still works after DisableTOTP: %v", err)
+		}
+		if err := s.SetPendingTOTPSecret(user.ID, "SECRET2"); err != nil {
+			t.Fatalf("SetPendingTOTPSecret: %v", err)
+		}
+		if err := s.EnableTOTP(user.ID, "SECRET2", 50, nil); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+		for _, tt := range []struct {
+			step int64
+			want bool
+		}{{50, false}, {51, true}} {
+			ok, err := s.UseTOTPStep(user.ID, tt.step)
+			if err != nil {
+				t.Fatalf("UseTOTPStep(%d): %v", tt.step, err)
+			}
+			if ok != tt.want {
+				t.Errorf("after re-enrolling, UseTOTPStep(%d) = %v, want %v", tt.step, ok, tt.want)
+			}
+		}
+	})
+}
+
+func TestUploadOffsetIsConditional(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+		video := createTestVideo(t, s, user.ID, "upload", VisibilityPublic)
+		upload, err := s.CreateUpload(CreateUploadParams{VideoID: video.ID, UserID: user.ID, Length: 10, ContentType: "video/mp4"})
+		if err != nil {
+			t.Fatalf("CreateUpload: %v", err)
+		}
+
+		if err := s.UpdateUploadOffset(upload.ID, 0, 4); err != nil {
+			t.Fatalf("UpdateUploadOffset: %v", err)
+		}
+		// A second writer that read offset 0 as well
+		if err := s.UpdateUploadOffset(upload.ID, 0, 6); !errors.Is(err, ErrUploadOffsetChanged) {
+			t.Errorf("update from a stale offset: got %v, want %v", err, ErrUploadOffsetChanged)
+		}
+		got, err := s.GetUpload(upload.ID)
+		if err != nil {
+			t.Fatalf("GetUpload: %v", err)
+		}
+		if got.Offset != 4 {
+			t.Errorf("offset = %d, want 4", got.Offset)
+		}
+
+		stale, err := s.ListStaleUploads(time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("ListStaleUploads: %v", err)
+		}
+		if len(stale) != 0 {
+			t.Errorf("fresh upload listed as stale")
+		}
+		stale, err = s.ListStaleUploads(time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("ListStaleUploads: %v", err)
+		}
+		if len(stale) != 1 || stale[0].ID != upload.ID {
+			t.Errorf("ListStaleUploads = %v, want the upload", stale)
+		}
+	})
+}
+
+func TestJobLeases(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+		video := createTestVideo(t, s, user.ID, "job", VisibilityPublic)
+		job, err := s.CreateJob(CreateJobParams{VideoID: video.ID, UserID: user.ID, InputKey: "raw/x.mp4", ContentType: "video/mp4", MaxAttempts: 3})
+		if err != nil {
+			t.Fatalf("CreateJob: %v", err)
+		}
+
+		claimed, err := s.ClaimNextJob(time.Now().Add(time.Minute))
+		if err != nil || claimed == nil {
+			t.Fatalf("ClaimNextJob = %v, %v", claimed, err)
+		}
+		if claimed.ID != job.ID || claimed.Status != JobStatusProcessing || claimed.Attempts != 1 {
+			t.Errorf("claimed job = %+v", claimed)
+		}
+		if again, err := s.ClaimNextJob(time.Now().Add(time.Minute)); err != nil || again != nil {
+			t.Errorf("claimed a job that is already running: %v, %v", again, err)
+		}
+
+		// A live lease belongs to a running worker, maybe in another instance
+		if err := s.RequeueExpiredJobs(time.Now()); err != nil {
+			t.Fatalf("RequeueExpiredJobs: %v", err)
+		}
+		got, err := s.GetJob(job.ID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if got.Status != JobStatusProcessing {
+			t.Errorf("job with a live lease was requeued")
+		}
+
+		if err := s.ExtendJobLease(job.ID, 2, time.Now().Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("extending another attempt's lease: got %v, want %v", err, ErrNotFound)
+		}
+		if err := s.RequeueExpiredJobs(time.Now().Add(2 * time.Minute)); err != nil {
+			t.Fatalf("RequeueExpiredJobs: %v", err)
+		}
+		got, err = s.GetJob(job.ID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if got.Status != JobStatusPending {
+			t.Errorf("job with an expired lease has status %s, want %s", got.Status, JobStatusPending)
+		}
+		if err := s.ExtendJobLease(job.ID, 1, time.Now().Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("extending a requeued job's lease: got %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestRetryJobRunAt(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+		video := createTestVideo(t, s, user.ID, "job", VisibilityPublic)
+		job, err := s.CreateJob(CreateJobParams{VideoID: video.ID, UserID: user.ID, InputKey: "raw/x.mp4", ContentType: "video/mp4", MaxAttempts: 3})
+		if err != nil {
+			t.Fatalf("CreateJob: %v", err)
+		}
+		if claimed, err := s.ClaimNextJob(time.Now().Add(time.Minute)); err != nil || claimed == nil {
+			t.Fatalf("ClaimNextJob = %v, %v", claimed, err)
+		}
+
+		if err := s.RetryJob(job.ID, "try again later", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("RetryJob: %v", err)
+		}
+		if claimed, err := s.ClaimNextJob(time.Now().Add(time.Minute)); err != nil || claimed != nil {
+			t.Errorf("claimed a job before its run_at: %v, %v", claimed, err)
+		}
+
+		if err := s.RetryJob(job.ID, "try again now", time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("RetryJob: %v", err)
+		}
+		claimed, err := s.ClaimNextJob(time.Now().Add(time.Minute))
+		if err != nil || claimed == nil || claimed.ID != job.ID || claimed.Attempts != 2 {
+			t.Errorf("ClaimNextJob after run_at = %+v, %v, want the retried job", claimed, err)
+		}
+	})
+}
+
+func TestGetVideoByAssetKey(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+		video := createTestVideo(t, s, user.ID, "assets", VisibilityPublic)
+		other := createTestVideo(t, s, user.ID, "no assets", VisibilityPublic)
+
+		videoKey := "landscape/abc.mp4"
+		video.VideoKey = &videoKey
+		video.Thumbnail = &Thumbnail{
+			SrcKey: "thumbnails/a_c%1.jpg",
+			Sizes: []ThumbnailSize{
+				{Name: "small", Width: 320, Height: 180, JPEGKey: "thumbnails/a_c%1.jpg", WebPKey: "thumbnails/a_c%1.webp"},
+			},
+		}
+		if err := s.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+
+		tests := []struct {
+			key  string
+			want uuid.UUID
+		}{
+			{"landscape/abc.mp4", video.ID},
+			{"thumbnails/a_c%1.jpg", video.ID},
+			{"thumbnails/a_c%1.webp", video.ID},
+			{"streams/" + other.ID.String() + "/job/master.m3u8", other.ID},
+			// Keys only match exactly
+			{"thumbnails/abc%1.jpg", uuid.Nil},
+			{"thumbnails/a_cX1.jpg", uuid.Nil},
+			{"thumbnails/a_c", uuid.Nil},
+			{"landscape/abc", uuid.Nil},
+			{"streams/" + uuid.NewString() + "/master.m3u8", uuid.Nil},
+			{"streams/not-a-uuid/master.m3u8", uuid.Nil},
+		}
+		for _, tt := range tests {
+			got, err := s.GetVideoByAssetKey(tt.key)
+			if tt.want == uuid.Nil {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("GetVideoByAssetKey(%q) = %s, %v, want %v", tt.key, got.ID, err, ErrNotFound)
+				}
+				continue
+			}
+			if err != nil || got.ID != tt.want {
+				t.Errorf("GetVideoByAssetKey(%q) = %s, %v, want %s", tt.key, got.ID, err, tt.want)
+			}
+		}
+
+		// Replaced and deleted assets no longer belong to the video
+		video.Thumbnail = nil
+		if err := s.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+		if _, err := s.GetVideoByAssetKey("thumbnails/a_c%1.jpg"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("replaced thumbnail: got %v, want %v", err, ErrNotFound)
+		}
+		if err := s.DeleteVideo(video.ID); err != nil {
+			t.Fatalf("DeleteVideo: %v", err)
+		}
+		if _, err := s.GetVideoByAssetKey(videoKey); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleted video: got %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestQueryVideosCreatedRange(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+		video := createTestVideo(t, s, user.ID, "dated", VisibilityPublic)
+
+		tests := []struct {
+			name string
+			q    VideoQuery
+			want bool
+		}{
+			{"after its creation time", VideoQuery{CreatedAfter: video.CreatedAt}, true},
+			{"after a later time", VideoQuery{CreatedAfter: video.CreatedAt.Add(time.Second)}, false},
+			{"before its creation time", VideoQuery{CreatedBefore: video.CreatedAt}, false},
+			{"before a later time", VideoQuery{CreatedBefore: video.CreatedAt.Add(time.Second)}, true},
+			{"in another time zone", VideoQuery{CreatedAfter: video.CreatedAt.In(time.FixedZone("UTC+5", 5*60*60))}, true},
+		}
+		for _, tt := range tests {
+			tt.q.Limit = 10
+			videos, _, err := s.QueryVideos(tt.q)
+			if err != nil {
+				t.Fatalf("%s: QueryVideos: %v", tt.name, err)
+			}
+			if got := len(videos) == 1; got != tt.want {
+				t.Errorf("%s: listed = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestCompleteDirectUploadOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createTestUser(t, s, "user@example.com")
+		video := createTestVideo(t, s, user.ID, "direct", VisibilityPublic)
+		other := createTestVideo(t, s, user.ID, "other", VisibilityPublic)
+
+		params := CreateDirectUploadParams{
+			Key:       "raw/" + video.ID.String() + "/a.mp4",
+			VideoID:   video.ID,
+			UserID:    user.ID,
+			UploadID:  "upload-1",
+			Size:      1234,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		if err := s.CreateDirectUpload(params); err != nil {
+			t.Fatalf("CreateDirectUpload: %v", err)
+		}
+		expired := params
+		expired.Key = "raw/" + video.ID.String() + "/expired.mp4"
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		if err := s.CreateDirectUpload(expired); err != nil {
+			t.Fatalf("CreateDirectUpload: %v", err)
+		}
+
+		if _, err := s.CompleteDirectUpload(params.Key, other.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("completing another video's upload: got %v, want %v", err, ErrNotFound)
+		}
+		got, err := s.CompleteDirectUpload(params.Key, video.ID)
+		if err != nil {
+			t.Fatalf("CompleteDirectUpload: %v", err)
+		}
+		if got.UploadID != params.UploadID || got.Size != params.Size || got.UserID != user.ID || got.CompletedAt == nil {
+			t.Errorf("completed upload = %+v, want %+v", got, params)
+		}
+		if _, err := s.CompleteDirectUpload(params.Key, video.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("completing twice: got %v, want %v", err, ErrNotFound)
+		}
+		if _, err := s.CompleteDirectUpload(expired.Key, video.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("completing an expired upload: got %v, want %v", err, ErrNotFound)
+		}
+		if _, err := s.CompleteDirectUpload("raw/"+video.ID.String()+"/unknown.mp4", video.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("completing an unknown key: got %v, want %v", err, ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The store interfaces are what the API needs from the database. Client
+// implements them with SQL and MemoryStore in process, for tests.
+
+type UserStore interface {
+	CreateUser(params CreateUserParams) (*User, error)
+	GetUser(id uuid.UUID) (*User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUsers() ([]User, error)
+	UpdateUserRole(id uuid.UUID, role Role) error
+	UpdateUserPassword(id uuid.UUID, password string) error
+	MarkEmailVerified(id uuid.UUID) error
+	DeleteUser(id uuid.UUID) error
+}
+
+type VideoStore interface {
+	CreateVideo(params CreateVideoParams) (Video, error)
+	GetVideo(id uuid.UUID) (Video, error)
+	GetVideoByAssetKey(key string) (Video, error)
+	GetVideos(userID uuid.UUID) ([]Video, error)
+	QueryVideos(q VideoQuery) ([]Video, *VideoCursor, error)
+	SearchVideos(q SearchQuery) ([]SearchResult, bool, error)
+	UpdateVideo(video Video) error
+	UpdateVideoProcessingStatus(id uuid.UUID, status ProcessingStatus) error
+	IncrementVideoViews(id uuid.UUID) error
+	SetVideoHidden(id uuid.UUID, hidden bool) error
+	DeleteVideo(id uuid.UUID) error
+}
+
+type TokenStore interface {
+	CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error)
+	GetRefreshToken(token string) (RefreshToken, error)
+	RotateRefreshToken(token, next string, expiresAt time.Time) (RefreshToken, error)
+	RevokeRefreshToken(token string) error
+	RevokeRefreshTokenFamily(familyID uuid.UUID) error
+	RevokeUserRefreshTokens(userID uuid.UUID) error
+	DeleteRefreshToken(token string) error
+}
+
+type EmailTokenStore interface {
+	CreateEmailToken(params CreateEmailTokenParams) error
+	ConsumeEmailToken(tokenHash string, purpose EmailTokenPurpose) (uuid.UUID, error)
+}
+
+type TOTPStore interface {
+	SetPendingTOTPSecret(userID uuid.UUID, secret string) error
+	EnableTOTP(userID uuid.UUID, secret string, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(userID uuid.UUID) error
+	UseTOTPStep(userID uuid.UUID, step int64) (bool, error)
+	UseRecoveryCode(userID uuid.UUID, codeHash string) error
+	CreateLoginChallenge(params CreateLoginChallengeParams) error
+	AttemptLoginChallenge(tokenHash string, maxAttempts int) (uuid.UUID, error)
+	DeleteLoginChallenge(tokenHash string) error
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(params CreateAPIKeyParams) (APIKey, error)
+	GetAPIKey(id uuid.UUID) (APIKey, error)
+	GetAPIKeyByHash(keyHash string) (APIKey, error)
+	GetAPIKeys(userID uuid.UUID) ([]APIKey, error)
+	MarkAPIKeyUsed(id uuid.UUID) error
+	RevokeAPIKey(id uuid.UUID) error
+}
+
+type JobStore interface {
+	CreateJob(params CreateJobParams) (Job, error)
+	GetJob(id uuid.UUID) (Job, error)
+	ClaimNextJob(leaseUntil time.Time) (*Job, error)
+	ExtendJobLease(id uuid.UUID, attempt int, leaseUntil time.Time) error
+	CompleteJob(id uuid.UUID) error
+	RetryJob(id uuid.UUID, lastError string, runAt time.Time) error
+	FailJob(id uuid.UUID, lastError string) error
+	RequeueExpiredJobs(now time.Time) error
+}
+
+type UploadStore interface {
+	CreateUpload(params CreateUploadParams) (Upload, error)
+	GetUpload(id uuid.UUID) (Upload, error)
+	UpdateUploadOffset(id uuid.UUID, from, to int64) error
+	ListStaleUploads(before time.Time) ([]Upload, error)
+	DeleteUpload(id uuid.UUID) error
+}
+
+type DirectUploadStore interface {
+	CreateDirectUpload(params CreateDirectUploadParams) error
+	CompleteDirectUpload(key string, videoID uuid.UUID) (DirectUpload, error)
+}
+
+// Store is everything the API keeps in the database.
+type Store interface {
+	UserStore
+	VideoStore
+	TokenStore
+	EmailTokenStore
+	TOTPStore
+	APIKeyStore
+	JobStore
+	UploadStore
+	DirectUploadStore
+	// Reset deletes every row, for the dev-only reset endpoint.
+	Reset() error
+}
+
+var (
+	_ Store = Client{}
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Thumbnail describes every stored rendition of a video's thumbnail. Only
+// the bucket and keys are stored; Src and each size's JPEG and WebP URLs are
+// filled in by handlers. Src is the fallback image for clients that don't
+// use srcset.
+type Thumbnail struct {
+	Src    string          `json:"src"`
+	Bucket string          `json:"-"`
+	SrcKey string          `json:"-"`
+	Sizes  []ThumbnailSize `json:"sizes"`
+}
+
+type ThumbnailSize struct {
+	Name    string `json:"name"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	JPEG    string `json:"jpeg"`
+	WebP    string `json:"webp"`
+	JPEGKey string `json:"-"`
+	WebPKey string `json:"-"`
+}
+
+// storedThumbnail is the JSON kept in the videos.thumbnail column.
+type storedThumbnail struct {
+	Bucket string                `json:"bucket,omitempty"`
+	SrcKey string                `json:"src_key"`
+	Sizes  []storedThumbnailSize `json:"sizes"`
+}
+
+type storedThumbnailSize struct {
+	Name    string `json:"name"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	JPEGKey string `json:"jpeg_key"`
+	WebPKey string `json:"webp_key"`
+}
+
+// thumbnailJSON has the API fields only, without the srcset strings
+// MarshalJSON adds.
+type thumbnailJSON Thumbnail
+
+// MarshalJSON adds ready to use srcset attribute values for each format.
+func (t Thumbnail) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		thumbnailJSON
+		JPEGSrcSet string `json:"jpeg_srcset"`
+		WebPSrcSet string `json:"webp_srcset"`
+	}{
+		thumbnailJSON: thumbnailJSON(t),
+		JPEGSrcSet:    t.SrcSet(func(s ThumbnailSize) string { return s.JPEG }),
+		WebPSrcSet:    t.SrcSet(func(s ThumbnailSize) string { return s.WebP }),
+	})
+}
+
+// SrcSet formats the sizes as an HTML srcset value, using url to pick the
+// rendition of each size.
+func (t Thumbnail) SrcSet(url func(ThumbnailSize) string) string {
+	candidates := []string{}
+	for _, size := range t.Sizes {
+		if u := url(size); u != "" {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", u, size.Width))
+		}
+	}
+	return strings.Join(candidates, ", ")
+}
+
+func (t Thumbnail) Value() (driver.Value, error) {
+	stored := storedThumbnail{
+		Bucket: t.Bucket,
+		SrcKey: t.SrcKey,
+		Sizes:  make([]storedThumbnailSize, 0, len(t.Sizes)),
+	}
+	for _, size := range t.Sizes {
+		stored.Sizes = append(stored.Sizes, storedThumbnailSize{
+			Name:    size.Name,
+			Width:   size.Width,
+			Height:  size.Height,
+			JPEGKey: size.JPEGKey,
+			WebPKey: size.WebPKey,
+		})
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (t *Thumbnail) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return errors.New("unsupported type for thumbnail")
+	}
+
+	var stored storedThumbnail
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*t = Thumbnail{
+		Bucket: stored.Bucket,
+		SrcKey: stored.SrcKey,
+		Sizes:  make([]ThumbnailSize, 0, len(stored.Sizes)),
+	}
+	for _, size := range stored.Sizes {
+		t.Sizes = append(t.Sizes, ThumbnailSize{
+			Name:    size.Name,
+			Width:   size.Width,
+			Height:  size.Height,
+			JPEGKey: size.JPEGKey,
+			WebPKey: size.WebPKey,
+		})
+	}
+	return nil
+}
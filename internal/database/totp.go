@@ -0,0 +1,150 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// SetPendingTOTPSecret starts enrolling the user in two-factor
+// authentication. An enabled secret keeps working until EnableTOTP replaces
+// it.
+func (c Client) SetPendingTOTPSecret(userID uuid.UUID, secret string) error {
+	query := `
+		UPDATE users
+		SET totp_pending_secret = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	res, err := c.db.Exec(query, secret, userID.String())
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnableTOTP makes the pending secret the user's TOTP secret and replaces
+// their recovery codes. step is the time step of the code that confirmed it.
+// It returns ErrNotFound when secret is no longer the pending one.
+func (c Client) EnableTOTP(userID uuid.UUID, secret string, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE users
+		SET totp_secret = totp_pending_secret,
+			totp_pending_secret = '',
+			totp_enabled_at = CURRENT_TIMESTAMP,
+			totp_last_step = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND totp_pending_secret = ? AND totp_pending_secret != ''
+	`, step, userID.String(), secret)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, userID.String(), hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns two-factor authentication off and deletes the user's
+// recovery codes and pending login challenges.
+func (c Client) DisableTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET totp_secret = '',
+			totp_pending_secret = '',
+			totp_enabled_at = NULL,
+			totp_last_step = 0,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM login_challenges WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for step was accepted. It returns false
+// when a code for this or a later step was already accepted, so each code
+// only works once.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET totp_last_step = ?
+		WHERE id = ? AND totp_last_step < ?
+	`
+	res, err := c.db.Exec(query, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated > 0, nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes. Unknown and used
+// codes are ErrNotFound.
+func (c Client) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	res, err := c.db.Exec(query, userID.String(), codeHash)
+	if err != nil {
+		return err
+	}
+	used, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUploadOffsetChanged is returned when an upload's offset moved under a
+// conditional update.
+var ErrUploadOffsetChanged = errors.New("upload offset changed")
+
+// Upload tracks a resumable (tus) upload that hasn't finished yet. The bytes
+// received so far live on disk; Offset is how many of them are committed.
+type Upload struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Offset    int64     `json:"offset"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Length      int64     `json:"length"`
+	ContentType string    `json:"content_type"`
+	Metadata    string    `json:"metadata"`
+}
+
+func (c Client) CreateUpload(params CreateUploadParams) (Upload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		content_type,
+		metadata
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.VideoID,
+		params.UserID,
+		params.Length,
+		params.ContentType,
+		params.Metadata,
+	)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	return c.GetUpload(id)
+}
+
+func (c Client) GetUpload(id uuid.UUID) (Upload, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		content_type,
+		metadata
+	FROM uploads
+	WHERE id = ?
+	`
+	var upload Upload
+	err := c.db.QueryRow(query, id).Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.ContentType,
+		&upload.Metadata,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Upload{}, ErrNotFound
+		}
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// UpdateUploadOffset moves an upload from one committed offset to another.
+// It returns ErrUploadOffsetChanged if the offset is no longer from, meaning
+// another request wrote to the upload in the meantime.
+func (c Client) UpdateUploadOffset(id uuid.UUID, from, to int64) error {
+	query := `
+	UPDATE uploads
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND upload_offset = ?
+	`
+	res, err := c.db.Exec(query, to, id, from)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrUploadOffsetChanged
+	}
+	return nil
+}
+
+// ListStaleUploads returns uploads that haven't been written to since before.
+func (c Client) ListStaleUploads(before time.Time) ([]Upload, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		content_type,
+		metadata
+	FROM uploads
+	WHERE updated_at < ?
+	`
+	rows, err := c.db.Query(query, c.db.dialect.timeArg(before))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		var upload Upload
+		err := rows.Scan(
+			&upload.ID,
+			&upload.CreatedAt,
+			&upload.UpdatedAt,
+			&upload.VideoID,
+			&upload.UserID,
+			&upload.Length,
+			&upload.Offset,
+			&upload.ContentType,
+			&upload.Metadata,
+		)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM uploads
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -8,24 +8,77 @@ import (
 	"github.com/google/uuid"
 )
 
+// Role decides what a user may do. Viewers can only watch, creators can also
+// upload, moderators can hide or delete anyone's videos, and admins can also
+// manage users.
+type Role string
+
+const (
+	RoleViewer    Role = "viewer"
+	RoleCreator   Role = "creator"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleViewer, RoleCreator, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Role      Role      `json:"role"`
+	// EmailVerifiedAt is nil until the user follows the link they were
+	// emailed.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// TOTPEnabledAt is set while two-factor authentication is on.
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"`
+	// TOTPSecret is the confirmed secret, and TOTPPendingSecret one that is
+	// waiting to be confirmed with a code.
+	TOTPSecret        string `json:"-"`
+	TOTPPendingSecret string `json:"-"`
+	// TokenVersion goes into access tokens. Changing the password bumps it,
+	// which revokes every access token issued before.
+	TokenVersion int `json:"-"`
 	CreateUserParams
 }
 
 type CreateUserParams struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email string `json:"email"`
+	// Password is the bcrypt hash, never sent to clients.
+	Password string `json:"-"`
+}
+
+const userColumns = `id, created_at, updated_at, role, email_verified_at, totp_enabled_at, totp_secret, totp_pending_secret, token_version, email, password`
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	var user User
+	var id string
+	err := row.Scan(
+		&id, &user.CreatedAt, &user.UpdatedAt, &user.Role, &user.EmailVerifiedAt,
+		&user.TOTPEnabledAt, &user.TOTPSecret, &user.TOTPPendingSecret, &user.TokenVersion,
+		&user.Email, &user.Password,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID, err = uuid.Parse(id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
 }
 
 func (c Client) GetUsers() ([]User, error) {
 	query := `
-		SELECT
-			id,
-			email
+		SELECT ` + userColumns + `
 		FROM users
+		ORDER BY created_at, email
 	`
 
 	rows, err := c.db.Query(query)
@@ -36,68 +89,32 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE email = ?
 	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, nil
+			return User{}, ErrNotFound
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
 	return user, nil
 }
 
-func (c Client) GetUserByRefreshToken(token string) (*User, error) {
-	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
-		FROM users u
-		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
-	`
-
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
-}
-
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 	id := uuid.New()
 
@@ -117,24 +134,70 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE id = ?
 	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
+	return &user, nil
+}
+
+func (c Client) UpdateUserRole(id uuid.UUID, role Role) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	res, err := c.db.Exec(query, role, id.String())
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &user, nil
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (c Client) MarkEmailVerified(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND email_verified_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+// UpdateUserPassword replaces the user's bcrypt password hash and bumps their
+// token version, so access tokens issued with the old password stop working.
+func (c Client) UpdateUserPassword(id uuid.UUID, password string) error {
+	query := `
+		UPDATE users
+		SET password = ?, token_version = token_version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	res, err := c.db.Exec(query, password, id.String())
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 func (c Client) DeleteUser(id uuid.UUID) error {
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VideoSort string
+
+const (
+	VideoSortNewest     VideoSort = "newest"
+	VideoSortOldest     VideoSort = "oldest"
+	VideoSortTitle      VideoSort = "title"
+	VideoSortMostViewed VideoSort = "most_viewed"
+)
+
+func (s VideoSort) Valid() bool {
+	switch s {
+	case VideoSortNewest, VideoSortOldest, VideoSortTitle, VideoSortMostViewed:
+		return true
+	}
+	return false
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// VideoQuery filters and pages through videos. Zero values mean no filter,
+// except that hidden videos are left out unless IncludeHidden is set.
+type VideoQuery struct {
+	OwnerID       uuid.UUID
+	Visibility    Visibility
+	IncludeHidden bool
+	AspectRatio   string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Sort          VideoSort
+	Limit         int
+	Cursor        *VideoCursor
+}
+
+// VideoCursor marks the last video of a page. It holds the sort key of that
+// video, with the ID as a tie breaker, so the next page starts right after
+// it even when rows are added in the meantime.
+type VideoCursor struct {
+	Sort      VideoSort `json:"s"`
+	CreatedAt time.Time `json:"c,omitempty"`
+	Title     string    `json:"t,omitempty"`
+	ViewCount int64     `json:"v,omitempty"`
+	ID        uuid.UUID `json:"i"`
+}
+
+// Encode turns the cursor into an opaque string for API clients.
+func (c VideoCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseVideoCursor(s string) (VideoCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	var cursor VideoCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || !cursor.Sort.Valid() {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+func newVideoCursor(sort VideoSort, video Video) VideoCursor {
+	cursor := VideoCursor{Sort: sort, ID: video.ID}
+	switch sort {
+	case VideoSortTitle:
+		cursor.Title = video.Title
+	case VideoSortMostViewed:
+		cursor.ViewCount = video.ViewCount
+	default:
+		cursor.CreatedAt = video.CreatedAt
+	}
+	return cursor
+}
+
+// QueryVideos returns one page of videos and the cursor for the next page,
+// which is nil on the last page.
+func (c Client) QueryVideos(q VideoQuery) ([]Video, *VideoCursor, error) {
+	if q.Sort == "" {
+		q.Sort = VideoSortNewest
+	}
+	if q.Cursor != nil && q.Cursor.Sort != q.Sort {
+		return nil, nil, fmt.Errorf("%w: it belongs to a different sort order", ErrInvalidCursor)
+	}
+
+	where := []string{}
+	args := []any{}
+	if q.OwnerID != uuid.Nil {
+		where = append(where, "user_id = ?")
+		args = append(args, q.OwnerID)
+	}
+	if q.Visibility != "" {
+		where = append(where, "visibility = ?")
+		args = append(args, q.Visibility)
+	}
+	if !q.IncludeHidden {
+		where = append(where, "NOT hidden")
+	}
+	if q.AspectRatio != "" {
+		where = append(where, "aspect_ratio = ?")
+		args = append(args, q.AspectRatio)
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, c.db.dialect.timeArg(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, c.db.dialect.timeArg(q.CreatedBefore))
+	}
+
+	var orderBy string
+	switch q.Sort {
+	case VideoSortNewest:
+		orderBy = "created_at DESC, id DESC"
+		if q.Cursor != nil {
+			at := c.db.dialect.timeArg(q.Cursor.CreatedAt)
+			where = append(where, "(created_at < ? OR (created_at = ? AND id < ?))")
+			args = append(args, at, at, q.Cursor.ID)
+		}
+	case VideoSortOldest:
+		orderBy = "created_at ASC, id ASC"
+		if q.Cursor != nil {
+			at := c.db.dialect.timeArg(q.Cursor.CreatedAt)
+			where = append(where, "(created_at > ? OR (created_at = ? AND id > ?))")
+			args = append(args, at, at, q.Cursor.ID)
+		}
+	case VideoSortTitle:
+		orderBy = "LOWER(title) ASC, id ASC"
+		if q.Cursor != nil {
+			where = append(where, "(LOWER(title) > LOWER(?) OR (LOWER(title) = LOWER(?) AND id > ?))")
+			args = append(args, q.Cursor.Title, q.Cursor.Title, q.Cursor.ID)
+		}
+	case VideoSortMostViewed:
+		orderBy = "view_count DESC, id DESC"
+		if q.Cursor != nil {
+			where = append(where, "(view_count < ? OR (view_count = ? AND id < ?))")
+			args = append(args, q.Cursor.ViewCount, q.Cursor.ViewCount, q.Cursor.ID)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	`
+	if len(where) > 0 {
+		query += "WHERE " + strings.Join(where, " AND ") + "\n"
+	}
+	// Fetch one extra row to find out whether there is another page
+	query += "ORDER BY " + orderBy + "\nLIMIT ?"
+	args = append(args, q.Limit+1)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(videos) <= q.Limit {
+		return videos, nil, nil
+	}
+	videos = videos[:q.Limit]
+	next := newVideoCursor(q.Sort, videos[len(videos)-1])
+	return videos, &next, nil
+}
@@ -3,37 +3,138 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ProcessingStatus string
+
+const (
+	ProcessingStatusPending    ProcessingStatus = "pending"
+	ProcessingStatusProcessing ProcessingStatus = "processing"
+	ProcessingStatusReady      ProcessingStatus = "ready"
+	ProcessingStatusFailed     ProcessingStatus = "failed"
+)
+
+// Visibility controls who can see a video. Private videos are only visible
+// to their owner, unlisted ones to anyone with the ID, and public ones are
+// also listed in the public feed.
+type Visibility string
+
+const (
+	VisibilityPrivate  Visibility = "private"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// Video stores the bucket and storage keys of its files. The matching URL
+// fields are not stored; handlers fill them in before responding. The
+// streaming manifests live in the same bucket as the video.
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Thumbnail        *Thumbnail `json:"thumbnail"`
+	VideoBucket      *string    `json:"-"`
+	VideoKey         *string    `json:"-"`
+	HLSManifestKey   *string    `json:"-"`
+	DASHManifestKey  *string    `json:"-"`
+	VideoURL         *string    `json:"video_url"`
+	HLSManifestURL   *string    `json:"hls_manifest_url"`
+	DASHManifestURL  *string    `json:"dash_manifest_url"`
+	StreamingFormats []string   `json:"streaming_formats"`
+	AspectRatio      *string    `json:"aspect_ratio"`
+	ViewCount        int64      `json:"view_count"`
+	// Hidden is set by moderators. Hidden videos are only visible to their
+	// owner, whatever their visibility.
+	Hidden           bool             `json:"hidden"`
+	ProcessingStatus ProcessingStatus `json:"processing_status"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Visibility  Visibility `json:"visibility"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
-		thumbnail_url,
-		video_url,
-		user_id
+		thumbnail,
+		video_key,
+		user_id,
+		processing_status,
+		hls_manifest_key,
+		dash_manifest_key,
+		video_bucket,
+		visibility,
+		aspect_ratio,
+		view_count,
+		hidden
+`
+
+func scanVideo(row interface{ Scan(...any) error }) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.Thumbnail,
+		&video.VideoKey,
+		&video.UserID,
+		&video.ProcessingStatus,
+		&video.HLSManifestKey,
+		&video.DASHManifestKey,
+		&video.VideoBucket,
+		&video.Visibility,
+		&video.AspectRatio,
+		&video.ViewCount,
+		&video.Hidden,
+	)
+	if err != nil {
+		return Video{}, err
+	}
+	video.StreamingFormats = streamingFormats(video)
+	return video, nil
+}
+
+// streamingFormats lists the ways a client can play the video, derived from
+// which of its keys are set.
+func streamingFormats(video Video) []string {
+	formats := []string{}
+	if video.VideoKey != nil {
+		formats = append(formats, "mp4")
+	}
+	if video.HLSManifestKey != nil {
+		formats = append(formats, "hls")
+	}
+	if video.DASHManifestKey != nil {
+		formats = append(formats, "dash")
+	}
+	return formats
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -47,17 +148,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -75,10 +167,15 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		processing_status,
+		visibility
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, ProcessingStatusPending, params.Visibility)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,32 +185,15 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Video{}, nil
+			return Video{}, ErrNotFound
 		}
 		return Video{}, err
 	}
@@ -122,34 +202,172 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 }
 
 func (c Client) UpdateVideo(video Video) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
-		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
+		thumbnail = ?,
+		video_key = ?,
+		user_id = ?,
+		processing_status = ?,
+		hls_manifest_key = ?,
+		dash_manifest_key = ?,
+		video_bucket = ?,
+		visibility = ?,
+		aspect_ratio = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	result, err := tx.Exec(
 		query,
 		video.Title,
 		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
+		&video.Thumbnail,
+		video.VideoKey,
 		video.UserID,
+		video.ProcessingStatus,
+		video.HLSManifestKey,
+		video.DASHManifestKey,
+		video.VideoBucket,
+		video.Visibility,
+		video.AspectRatio,
 		video.ID,
 	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM video_assets WHERE video_id = ?", video.ID)
+	if err != nil {
+		return err
+	}
+	for _, key := range videoAssetKeys(video) {
+		_, err = tx.Exec(`
+		INSERT INTO video_assets (key, video_id) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET video_id = excluded.video_id
+		`, key, video.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// videoAssetKeys lists the objects GetVideoByAssetKey finds video by.
+// Streams are left out, their keys already start with the video ID.
+func videoAssetKeys(video Video) []string {
+	keys := []string{}
+	if video.VideoKey != nil && *video.VideoKey != "" {
+		keys = append(keys, *video.VideoKey)
+	}
+	if video.Thumbnail != nil {
+		for _, size := range video.Thumbnail.Sizes {
+			for _, key := range []string{size.JPEGKey, size.WebPKey} {
+				if key != "" && !slices.Contains(keys, key) {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// UpdateVideoProcessingStatus only touches the status column, so background
+// workers don't overwrite edits made to the rest of the row in the meantime.
+func (c Client) UpdateVideoProcessingStatus(id uuid.UUID, status ProcessingStatus) error {
+	query := `
+	UPDATE videos
+	SET
+		processing_status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, id)
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// IncrementVideoViews counts one view of a video without touching the rest
+// of the row.
+func (c Client) IncrementVideoViews(id uuid.UUID) error {
 	query := `
-	DELETE FROM videos
+	UPDATE videos
+	SET view_count = view_count + 1
 	WHERE id = ?
 	`
 	_, err := c.db.Exec(query, id)
 	return err
 }
+
+// SetVideoHidden hides a video from everyone but its owner, or shows it
+// again.
+func (c Client) SetVideoHidden(id uuid.UUID, hidden bool) error {
+	query := `
+	UPDATE videos
+	SET
+		hidden = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hidden, id)
+	return err
+}
+
+func (c Client) DeleteVideo(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_assets WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
+	query := `
+	DELETE FROM videos
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetVideoByAssetKey returns the video a stored object belongs to: its
+// processed file, one of its thumbnail renditions, or anything under its
+// streams/<videoID>/ prefix. Objects no video references are ErrNotFound.
+func (c Client) GetVideoByAssetKey(key string) (Video, error) {
+	if rest, ok := strings.CutPrefix(key, "streams/"); ok {
+		id, err := uuid.Parse(strings.SplitN(rest, "/", 2)[0])
+		if err != nil {
+			return Video{}, ErrNotFound
+		}
+		return c.GetVideo(id)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = (SELECT video_id FROM video_assets WHERE key = ?)
+	`
+	video, err := scanVideo(c.db.QueryRow(query, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, ErrNotFound
+		}
+		return Video{}, err
+	}
+	return video, nil
+}
@@ -0,0 +1,33 @@
+// Package mail sends the emails Tubely needs, such as verification links.
+package mail
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var errHeaderNewline = errors.New("email headers can't contain newlines")
+
+// checkHeaders rejects header values that would let a caller inject headers
+// of their own.
+func checkHeaders(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return errHeaderNewline
+		}
+	}
+	return nil
+}
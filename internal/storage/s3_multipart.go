@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// S3 rejects parts smaller than 5MiB (except the last one) and uploads
+	// with more than 10,000 parts.
+	minPartSize = 5 << 20
+	maxParts    = 10000
+)
+
+// MultipartConfig controls how S3Store.Put splits large bodies.
+type MultipartConfig struct {
+	// PartSize is both the size of each part and the threshold above which
+	// Put switches from a single PutObject to a multipart upload.
+	PartSize int64
+	// Concurrency is how many parts are uploaded at once. Memory use is
+	// roughly PartSize * Concurrency.
+	Concurrency int
+	// PartRetries is how many times a failed part is re-sent before the
+	// whole upload is aborted.
+	PartRetries int
+}
+
+func DefaultMultipartConfig() MultipartConfig {
+	return MultipartConfig{
+		PartSize:    16 << 20,
+		Concurrency: 4,
+		PartRetries: 3,
+	}
+}
+
+func (c MultipartConfig) withDefaults() MultipartConfig {
+	defaults := DefaultMultipartConfig()
+	if c.PartSize == 0 {
+		c.PartSize = defaults.PartSize
+	}
+	if c.PartSize < minPartSize {
+		c.PartSize = minPartSize
+	}
+	if c.Concurrency < 1 {
+		c.Concurrency = defaults.Concurrency
+	}
+	if c.PartRetries < 0 {
+		c.PartRetries = 0
+	}
+	return c
+}
+
+// putMultipart streams body to S3 in PartSize chunks. first holds the bytes
+// already read by Put to decide between a single and a multipart upload.
+// Parts are uploaded in parallel and retried individually; if any part
+// still fails the upload is aborted so no orphaned parts are billed.
+func (s *S3Store) putMultipart(ctx context.Context, key string, first []byte, body io.Reader, contentType string) error {
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return err
+	}
+	uploadID := created.UploadId
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	sem := make(chan struct{}, s.multipart.Concurrency)
+	chunk := first
+	last := false
+	for partNumber := int32(1); ; partNumber++ {
+		if partNumber > maxParts {
+			fail(fmt.Errorf("object needs more than %d parts, increase the part size", maxParts))
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int32, data []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			etag, err := s.uploadPart(ctx, key, uploadID, partNumber, data)
+			if err != nil {
+				fail(fmt.Errorf("part %d: %w", partNumber, err))
+				return
+			}
+			mu.Lock()
+			parts = append(parts, types.CompletedPart{
+				PartNumber: aws.Int32(partNumber),
+				ETag:       etag,
+			})
+			mu.Unlock()
+		}(partNumber, chunk)
+
+		if last {
+			break
+		}
+		chunk = make([]byte, s.multipart.PartSize)
+		n, err := io.ReadFull(body, chunk)
+		chunk = chunk[:n]
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			last = true
+		} else if err != nil {
+			fail(err)
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		s.abortMultipart(key, uploadID)
+		return firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return err
+	}
+	return nil
+}
+
+func (s *S3Store) uploadPart(ctx context.Context, key string, uploadID *string, partNumber int32, data []byte) (*string, error) {
+	var err error
+	for attempt := 0; attempt <= s.multipart.PartRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		var out *s3.UploadPartOutput
+		out, err = s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          bytes.NewReader(data),
+			ContentLength: aws.Int64(int64(len(data))),
+		})
+		if err == nil {
+			return out.ETag, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// abortMultipart runs on its own context: the upload's context is usually
+// already cancelled by the time we get here.
+func (s *S3Store) abortMultipart(key string, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+}
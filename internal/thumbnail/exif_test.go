@@ -0,0 +1,145 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifJPEG returns a small JPEG with an EXIF segment holding tiff.
+func exifJPEG(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 16)), nil); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+2))
+	data = append(data, segment...)
+	return append(data, buf.Bytes()[2:]...)
+}
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// orientationTIFF returns a TIFF header and IFD0 with one orientation entry.
+func orientationTIFF(order byteOrder, orientation uint16) []byte {
+	tiff := []byte("II")
+	if order == binary.BigEndian {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, 0x0112)
+	tiff = order.AppendUint16(tiff, 3) // SHORT
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	return order.AppendUint32(tiff, 0)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+			data := exifJPEG(t, orientationTIFF(order, orientation))
+			if got := jpegOrientation(data); got != int(orientation) {
+				t.Errorf("%v orientation %d: got %d", order, orientation, got)
+			}
+		}
+	}
+}
+
+func TestJPEGOrientationMalformed(t *testing.T) {
+	valid := orientationTIFF(binary.BigEndian, 6)
+	withIFD := func(offset uint32) []byte {
+		tiff := bytes.Clone(valid)
+		binary.BigEndian.PutUint32(tiff[4:], offset)
+		return tiff
+	}
+	// Walks past the end looking for the orientation entry
+	pastEnd := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(pastEnd[8:], 0xFFFF)
+	binary.BigEndian.PutUint16(pastEnd[10:], 0x0110)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a JPEG", []byte("GIF89a")},
+		{"no EXIF", exifJPEG(t, nil)[:2]},
+		{"unknown byte order", exifJPEG(t, append([]byte("XX"), valid[2:]...))},
+		{"truncated header", exifJPEG(t, valid[:6])},
+		{"IFD offset past the end", exifJPEG(t, withIFD(uint32(len(valid))))},
+		{"IFD offset overflows", exifJPEG(t, withIFD(0xFFFFFFFF))},
+		{"entry count past the end", exifJPEG(t, pastEnd)},
+		{"truncated entry", exifJPEG(t, valid[:len(valid)-10])},
+		{"orientation out of range", exifJPEG(t, orientationTIFF(binary.BigEndian, 9))},
+		{"orientation zero", exifJPEG(t, orientationTIFF(binary.BigEndian, 0))},
+		{"segment past the end", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f', 0, 0}},
+		{"segment length too short", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xD9}},
+		{"not a marker", []byte{0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x08}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != 1 {
+				t.Errorf("jpegOrientation = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	// 3x2, with the two left pixels of the top row marked
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red)
+	src.Set(1, 0, green)
+
+	tests := []struct {
+		orientation   int
+		width, height int
+		red, green    image.Point
+	}{
+		{1, 3, 2, image.Pt(0, 0), image.Pt(1, 0)},
+		{2, 3, 2, image.Pt(2, 0), image.Pt(1, 0)},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(1, 1)},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(1, 1)},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(0, 1)},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(1, 1)},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(1, 1)},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orientation %d: %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.width, tt.height)
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.red.X, tt.red.Y)); c != red {
+			t.Errorf("orientation %d: %v is %v, want red", tt.orientation, tt.red, c)
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.green.X, tt.green.Y)); c != green {
+			t.Errorf("orientation %d: %v is %v, want green", tt.orientation, tt.green, c)
+		}
+	}
+}
+
+func TestDecodeAppliesOrientation(t *testing.T) {
+	data := exifJPEG(t, orientationTIFF(binary.LittleEndian, 6))
+	img, err := Decode(bytes.NewReader(data), DefaultLimits)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 32 {
+		t.Errorf("rotated image is %dx%d, want 16x32", b.Dx(), b.Dy())
+	}
+}
@@ -0,0 +1,194 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+var (
+	ErrTooSmall    = errors.New("image is too small")
+	ErrTooLarge    = errors.New("image is too large")
+	ErrUnsupported = errors.New("unsupported image format")
+)
+
+var allowedFormats = map[string]bool{"jpeg": true, "png": true}
+
+const jpegQuality = 85
+
+// Limits bound what Decode accepts. Dimensions are checked from the image
+// header before the pixels are decoded, so oversized images are rejected
+// without allocating memory for them.
+type Limits struct {
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+}
+
+var DefaultLimits = Limits{
+	MinWidth:  16,
+	MinHeight: 16,
+	MaxWidth:  8192,
+	MaxHeight: 8192,
+	MaxPixels: 40_000_000,
+}
+
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes are the widths generated for every thumbnail, smallest first.
+var Sizes = []Size{
+	{Name: "small", Width: 320},
+	{Name: "medium", Width: 640},
+	{Name: "large", Width: 1280},
+}
+
+// SizesFor returns the sizes worth generating for an image width wide:
+// every size it can be scaled down to, plus the next one up, which keeps
+// the image's own width. Larger sizes would only be upscaled copies.
+func SizesFor(width int) []Size {
+	for i, size := range Sizes {
+		if size.Width >= width {
+			return Sizes[:i+1]
+		}
+	}
+	return Sizes
+}
+
+// Decode reads a JPEG or PNG image and checks it against limits. JPEG EXIF
+// orientation is applied to the pixels; the metadata itself is dropped, so
+// anything encoded from the result carries no EXIF.
+func Decode(r io.Reader, limits Limits) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if !allowedFormats[format] {
+		return nil, ErrUnsupported
+	}
+	if config.Width < limits.MinWidth || config.Height < limits.MinHeight {
+		return nil, ErrTooSmall
+	}
+	if config.Width > limits.MaxWidth || config.Height > limits.MaxHeight || config.Width*config.Height > limits.MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+	return img, nil
+}
+
+// Resize scales img down to width, keeping its aspect ratio. Images that are
+// already narrower are returned unchanged.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// EncodeJPEG encodes img as a JPEG. JPEG has no alpha channel, so
+// transparent images are composited onto white first instead of coming out
+// black.
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = flat
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+}
+
+// WebPSupported reports whether ffmpeg has the libwebp encoder EncodeWebP
+// needs. Many ffmpeg builds leave it out.
+func WebPSupported(ctx context.Context) bool {
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == "libwebp" {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeWebP encodes img with ffmpeg's libwebp encoder, since the standard
+// library can only decode WebP.
+func EncodeWebP(ctx context.Context, w io.Writer, img image.Image) error {
+	dir, err := os.MkdirTemp("", "tubely-webp-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	inPath := filepath.Join(dir, "in.png")
+	outPath := filepath.Join(dir, "out.webp")
+
+	in, err := os.Create(inPath)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(in, img)
+	in.Close()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffmpeg",
+		"-i", inPath,
+		"-c:v", "libwebp",
+		"-quality", "80",
+		"-map_metadata", "-1",
+		outPath,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("ffmpeg error: %v, details: %s", err, stderr.String())
+	}
+
+	out, err := os.Open(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(w, out)
+	return err
+}
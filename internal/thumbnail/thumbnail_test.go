@@ -0,0 +1,122 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeLimits(t *testing.T) {
+	limits := Limits{MinWidth: 4, MinHeight: 4, MaxWidth: 64, MaxHeight: 64, MaxPixels: 1000}
+
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9), nil); err != nil {
+		t.Fatalf("gif.Encode: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"smallest allowed", encodePNG(t, 4, 4), nil},
+		{"largest allowed", encodePNG(t, 64, 15), nil},
+		{"most pixels allowed", encodePNG(t, 40, 25), nil},
+		{"too narrow", encodePNG(t, 3, 10), ErrTooSmall},
+		{"too short", encodePNG(t, 10, 3), ErrTooSmall},
+		{"too wide", encodePNG(t, 65, 4), ErrTooLarge},
+		{"too tall", encodePNG(t, 4, 65), ErrTooLarge},
+		{"too many pixels", encodePNG(t, 40, 26), ErrTooLarge},
+		{"GIF", gifData.Bytes(), ErrUnsupported},
+		{"not an image", []byte("hello"), ErrUnsupported},
+		{"truncated", encodePNG(t, 16, 16)[:60], ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(bytes.NewReader(tt.data), limits)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Decode = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 360))
+	if b := Resize(img, 320).Bounds(); b.Dx() != 320 || b.Dy() != 180 {
+		t.Errorf("Resize to 320 = %dx%d, want 320x180", b.Dx(), b.Dy())
+	}
+	if got := Resize(img, 1280); got != image.Image(img) {
+		t.Error("Resize scaled up an image narrower than the width")
+	}
+}
+
+func TestEncodeJPEGTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	// Left half fully transparent, right half opaque red
+	for y := 0; y < 16; y++ {
+		for x := 8; x < 16; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, img); err != nil {
+		t.Fatalf("EncodeJPEG: %v", err)
+	}
+	got, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatalf("jpeg.Decode: %v", err)
+	}
+
+	near := func(c color.Color, r, g, b uint8) bool {
+		got := color.RGBAModel.Convert(c).(color.RGBA)
+		diff := func(a, b uint8) int { return max(int(a), int(b)) - min(int(a), int(b)) }
+		return diff(got.R, r) < 16 && diff(got.G, g) < 16 && diff(got.B, b) < 16
+	}
+	if c := got.At(2, 8); !near(c, 255, 255, 255) {
+		t.Errorf("transparent pixel came out %v, want white", c)
+	}
+	if c := got.At(13, 8); !near(c, 255, 0, 0) {
+		t.Errorf("opaque pixel came out %v, want red", c)
+	}
+}
+
+func TestSizesFor(t *testing.T) {
+	tests := []struct {
+		width int
+		want  []string
+	}{
+		{16, []string{"small"}},
+		{320, []string{"small"}},
+		{500, []string{"small", "medium"}},
+		{640, []string{"small", "medium"}},
+		{641, []string{"small", "medium", "large"}},
+		{4000, []string{"small", "medium", "large"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, size := range SizesFor(tt.width) {
+			got = append(got, size.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("SizesFor(%d) = %v, want %v", tt.width, got, tt.want)
+		}
+	}
+}
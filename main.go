@@ -4,18 +4,29 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/delivery"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnail"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
+	db               database.Store
 	jwtSecret        string
 	platform         string
 	filepathRoot     string
@@ -26,17 +37,52 @@ type apiConfig struct {
 	port             string
 	s3Client         *s3.Client
 	CFD              string
+	storage          storage.Store
+	deliveryMode     string
+	urls             delivery.Resolver
+	cookieDomain     string
+	jobMaxAttempts   int
+	jobWake          chan struct{}
+	hlsEnabled       bool
+	dashEnabled      bool
+	uploadsRoot      string
+	thumbnailMode    thumbnailMode
+	thumbnailOffset  time.Duration
+	webpEnabled      bool
+	mailer           mail.Mailer
+	appURL           *url.URL
+	// resetIPLimiter and resetEmailLimiter limit password reset emails.
+	resetIPLimiter    *rateLimiter
+	resetEmailLimiter *rateLimiter
+	// viewLimiter keeps repeat views from being counted.
+	viewLimiter *rateLimiter
+	// tusLocks holds the IDs of tus uploads a request is writing to.
+	tusLocks sync.Map
 }
 
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
+	// DB_URL takes a Postgres connection string or a SQLite file path.
+	// DB_PATH is the older name for the SQLite path.
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(dbURL, os.Args[2:]))
+		case "set-role":
+			os.Exit(runSetRole(dbURL, os.Args[2:]))
+		}
+	}
+
+	db, err := database.NewClient(dbURL)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
@@ -61,76 +107,286 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	videoWorkers := 2
+	if v := os.Getenv("VIDEO_WORKERS"); v != "" {
+		videoWorkers, err = strconv.Atoi(v)
+		if err != nil || videoWorkers < 1 {
+			log.Fatal("VIDEO_WORKERS must be a positive integer")
+		}
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	jobMaxAttempts := 3
+	if v := os.Getenv("JOB_MAX_ATTEMPTS"); v != "" {
+		jobMaxAttempts, err = strconv.Atoi(v)
+		if err != nil || jobMaxAttempts < 1 {
+			log.Fatal("JOB_MAX_ATTEMPTS must be a positive integer")
+		}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	uploadsRoot := os.Getenv("UPLOADS_ROOT")
+	if uploadsRoot == "" {
+		uploadsRoot = filepath.Join(os.TempDir(), "tubely-uploads")
+	}
+	err = os.MkdirAll(uploadsRoot, 0755)
+	if err != nil {
+		log.Fatalf("Couldn't create uploads directory: %v", err)
+	}
+
+	thumbnailMode := thumbnailMode(os.Getenv("THUMBNAIL_MODE"))
+	switch thumbnailMode {
+	case "":
+		thumbnailMode = thumbnailModeTimestamp
+	case "off":
+		thumbnailMode = ""
+	case thumbnailModeTimestamp, thumbnailModeScene:
+	default:
+		log.Fatal("THUMBNAIL_MODE must be off, timestamp or scene")
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	thumbnailOffset, err := parseThumbnailOffset(os.Getenv("THUMBNAIL_OFFSET"))
 	if err != nil {
-		log.Fatal("Couldn't load AWS config:", err)
+		log.Fatalf("Invalid THUMBNAIL_OFFSET: %v", err)
 	}
 
-	s3Client := s3.NewFromConfig(awsCfg)
-	CFD := os.Getenv("CFD_DOMAIN")
+	webpEnabled := thumbnail.WebPSupported(context.Background())
+	if !webpEnabled {
+		log.Print("ffmpeg has no libwebp encoder, thumbnails will only be stored as JPEG")
+	}
 
-	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
-		CFD:              CFD,
+	hlsEnabled, dashEnabled := false, false
+	for _, format := range strings.Split(os.Getenv("STREAMING_FORMATS"), ",") {
+		switch strings.TrimSpace(format) {
+		case "":
+		case "hls":
+			hlsEnabled = true
+		case "dash":
+			dashEnabled = true
+		default:
+			log.Fatalf("Unknown streaming format %q in STREAMING_FORMATS, expected hls or dash", format)
+		}
 	}
 
-	err = cfg.ensureAssetsDir()
+	var mailer mail.Mailer
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		mailLog := log.Writer()
+		if path := os.Getenv("MAIL_LOG_FILE"); path != "" {
+			mailLog, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatalf("Couldn't open MAIL_LOG_FILE: %v", err)
+			}
+		}
+		mailer = mail.NewLogMailer(mailLog)
+	case "smtp":
+		smtpHost := os.Getenv("SMTP_HOST")
+		if smtpHost == "" {
+			log.Fatal("MAILER=smtp needs SMTP_HOST")
+		}
+		smtpPort := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			smtpPort, err = strconv.Atoi(v)
+			if err != nil || smtpPort < 1 {
+				log.Fatal("SMTP_PORT must be a positive integer")
+			}
+		}
+		mailFrom := os.Getenv("MAIL_FROM")
+		if mailFrom == "" {
+			log.Fatal("MAILER=smtp needs MAIL_FROM")
+		}
+		mailer = mail.NewSMTPMailer(smtpHost, smtpPort, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+	default:
+		log.Fatalf("Unknown MAILER %q, expected log or smtp", os.Getenv("MAILER"))
+	}
+
+	// APP_URL is where links in emails point, with the token added to the
+	// query string.
+	appURL, err := url.Parse(os.Getenv("APP_URL"))
 	if err != nil {
-		log.Fatalf("Couldn't create assets directory: %v", err)
+		log.Fatalf("Invalid APP_URL: %v", err)
+	}
+	if os.Getenv("APP_URL") == "" {
+		appURL = &url.URL{Scheme: "http", Host: "localhost:" + port, Path: "/app/"}
 	}
 
-	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
-	mux.Handle("/app/", appHandler)
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
+	}
+
+	cfg := apiConfig{
+		db:                db,
+		jwtSecret:         jwtSecret,
+		platform:          platform,
+		filepathRoot:      filepathRoot,
+		assetsRoot:        assetsRoot,
+		port:              port,
+		jobMaxAttempts:    jobMaxAttempts,
+		jobWake:           make(chan struct{}, 1),
+		hlsEnabled:        hlsEnabled,
+		dashEnabled:       dashEnabled,
+		uploadsRoot:       uploadsRoot,
+		thumbnailMode:     thumbnailMode,
+		thumbnailOffset:   thumbnailOffset,
+		webpEnabled:       webpEnabled,
+		mailer:            mailer,
+		appURL:            appURL,
+		resetIPLimiter:    newRateLimiter(resetEmailsPerIP, resetEmailWindow),
+		resetEmailLimiter: newRateLimiter(resetEmailsPerAddress, resetEmailWindow),
+		viewLimiter:       newRateLimiter(1, viewWindow),
+	}
+
+	switch storageBackend {
+	case "s3":
+		s3Bucket := os.Getenv("S3_BUCKET")
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+		s3Region := os.Getenv("S3_REGION")
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+
+		s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+		if s3CfDistribution == "" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
+
+		awsCfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal("Couldn't load AWS config:", err)
+		}
+
+		// S3_ENDPOINT points the client at an S3 compatible server such as
+		// MinIO, which only supports path style addressing.
+		s3Endpoint := os.Getenv("S3_ENDPOINT")
+
+		cfg.s3Bucket = s3Bucket
+		cfg.s3Region = s3Region
+		cfg.s3CfDistribution = s3CfDistribution
+		cfg.s3Client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			if s3Endpoint != "" {
+				o.BaseEndpoint = aws.String(s3Endpoint)
+				o.UsePathStyle = true
+			}
+		})
+		cfg.CFD = os.Getenv("CFD_DOMAIN")
+		multipart := storage.DefaultMultipartConfig()
+		if v := os.Getenv("S3_PART_SIZE_MB"); v != "" {
+			partSizeMB, err := strconv.Atoi(v)
+			if err != nil || partSizeMB < 5 {
+				log.Fatal("S3_PART_SIZE_MB must be an integer of at least 5")
+			}
+			multipart.PartSize = int64(partSizeMB) << 20
+		}
+		if v := os.Getenv("S3_UPLOAD_CONCURRENCY"); v != "" {
+			multipart.Concurrency, err = strconv.Atoi(v)
+			if err != nil || multipart.Concurrency < 1 {
+				log.Fatal("S3_UPLOAD_CONCURRENCY must be a positive integer")
+			}
+		}
+		if v := os.Getenv("S3_PART_RETRIES"); v != "" {
+			multipart.PartRetries, err = strconv.Atoi(v)
+			if err != nil || multipart.PartRetries < 0 {
+				log.Fatal("S3_PART_RETRIES must be a non-negative integer")
+			}
+		}
+		cfg.storage = storage.NewS3Store(cfg.s3Client, cfg.s3Bucket, multipart)
+	case "local":
+		cfg.storage = storage.NewLocalStore(assetsRoot)
+	case "memory":
+		cfg.storage = storage.NewMemoryStore()
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, expected s3, local or memory", storageBackend)
+	}
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	deliveryMode := os.Getenv("DELIVERY_MODE")
+	if deliveryMode == "" {
+		deliveryMode = "base"
+		if storageBackend == "s3" {
+			deliveryMode = "cdn"
+		}
+	}
+	cfg.deliveryMode = deliveryMode
+	urlTTL := 15 * time.Minute
+	if v := os.Getenv("DELIVERY_URL_TTL"); v != "" {
+		urlTTL, err = time.ParseDuration(v)
+		if err != nil || urlTTL <= 0 {
+			log.Fatal("DELIVERY_URL_TTL must be a positive duration")
+		}
+	}
+	switch deliveryMode {
+	case "base":
+		if storageBackend == "s3" {
+			log.Fatal("DELIVERY_MODE=base needs the local or memory storage backend")
+		}
+		publicBaseURL := os.Getenv("PUBLIC_BASE_URL")
+		if publicBaseURL == "" {
+			publicBaseURL = "http://localhost:" + port
+		}
+		cfg.urls = delivery.NewBaseURLResolver(publicBaseURL, []byte(jwtSecret), urlTTL)
+	case "cdn":
+		cfg.urls, err = delivery.NewCDNResolver(cfg.CFD)
+		if err != nil {
+			log.Fatalf("DELIVERY_MODE=cdn needs CFD_DOMAIN: %v", err)
+		}
+		err = cfg.checkCDNVisibility()
+		if err != nil {
+			log.Fatalf("DELIVERY_MODE=cdn would serve videos that aren't public from unsigned URLs (%v), use DELIVERY_MODE=signed", err)
+		}
+	case "signed":
+		keyPairID := os.Getenv("CLOUDFRONT_KEY_PAIR_ID")
+		if keyPairID == "" {
+			log.Fatal("DELIVERY_MODE=signed needs CLOUDFRONT_KEY_PAIR_ID")
+		}
+		keyData, err := os.ReadFile(os.Getenv("CLOUDFRONT_PRIVATE_KEY_FILE"))
+		if err != nil {
+			log.Fatalf("Couldn't read CLOUDFRONT_PRIVATE_KEY_FILE: %v", err)
+		}
+		privateKey, err := cfsign.ParsePrivateKey(keyData)
+		if err != nil {
+			log.Fatalf("Couldn't parse CloudFront private key: %v", err)
+		}
+		cfg.urls, err = delivery.NewSignedCDNResolver(cfg.CFD, cfsign.NewSigner(keyPairID, privateKey), urlTTL)
+		if err != nil {
+			log.Fatalf("DELIVERY_MODE=signed needs CFD_DOMAIN: %v", err)
+		}
+		cfg.cookieDomain = os.Getenv("CLOUDFRONT_COOKIE_DOMAIN")
+	case "presigned":
+		presigner, ok := cfg.storage.(storage.Presigner)
+		if !ok {
+			log.Fatal("DELIVERY_MODE=presigned needs the s3 storage backend")
+		}
+		// Manifests reference their segments by relative URLs, which a
+		// presigned manifest URL can't extend its signature to
+		if hlsEnabled || dashEnabled {
+			log.Fatal("DELIVERY_MODE=presigned can't serve STREAMING_FORMATS, use cdn or signed")
+		}
+		cfg.urls = delivery.NewPresignResolver(presigner, urlTTL)
+	default:
+		log.Fatalf("Unknown DELIVERY_MODE %q, expected base, cdn, signed or presigned", deliveryMode)
+	}
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	err = cfg.ensureAssetsDir()
+	if err != nil {
+		log.Fatalf("Couldn't create assets directory: %v", err)
+	}
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	// This was used for the in-memory thumbnail storage
-	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	err = cfg.startVideoWorkers(context.Background(), videoWorkers)
+	if err != nil {
+		log.Fatalf("Couldn't start video workers: %v", err)
+	}
+	go cfg.runTusCleanup(context.Background())
+	if presigner, ok := cfg.storage.(storage.Presigner); ok {
+		go cfg.runMultipartCleanup(context.Background(), presigner)
+	}
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux := cfg.routes(storageBackend)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
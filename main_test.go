@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/delivery"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const testJWTSecret = "test-jwt-secret"
+
+func TestMain(m *testing.M) {
+	// Handlers log every error response
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testMailer keeps sent emails so tests can follow their links.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// linkToken returns the token in the param query parameter of the latest
+// email sent to, or "" if there is none.
+func (m *testMailer) linkToken(to, param string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	re := regexp.MustCompile(`[?&]` + param + `=([^&\s]+)`)
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To != to {
+			continue
+		}
+		if match := re.FindStringSubmatch(m.messages[i].Body); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// waitForLinkToken is linkToken for emails sent in the background, which
+// may take a moment to arrive.
+func (m *testMailer) waitForLinkToken(t *testing.T, to, param string) string {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if token := m.linkToken(to, param); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// testAPI is the whole API on in-memory stores, the way main wires it with
+// STORAGE_BACKEND=memory. Video workers aren't started, so queued jobs stay
+// pending.
+type testAPI struct {
+	cfg     *apiConfig
+	db      *database.MemoryStore
+	storage *storage.MemoryStore
+	mailer  *testMailer
+	handler http.Handler
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	api := &testAPI{
+		db:      database.NewMemoryStore(),
+		storage: storage.NewMemoryStore(),
+		mailer:  &testMailer{},
+	}
+	api.cfg = &apiConfig{
+		db:                api.db,
+		jwtSecret:         testJWTSecret,
+		platform:          "dev",
+		filepathRoot:      t.TempDir(),
+		assetsRoot:        t.TempDir(),
+		port:              "8091",
+		storage:           api.storage,
+		deliveryMode:      "base",
+		urls:              delivery.NewBaseURLResolver("http://localhost:8091", []byte(testJWTSecret), 15*time.Minute),
+		jobMaxAttempts:    3,
+		jobWake:           make(chan struct{}, 1),
+		uploadsRoot:       t.TempDir(),
+		mailer:            api.mailer,
+		appURL:            &url.URL{Scheme: "http", Host: "localhost:8091", Path: "/app/"},
+		resetIPLimiter:    newRateLimiter(resetEmailsPerIP, resetEmailWindow),
+		resetEmailLimiter: newRateLimiter(resetEmailsPerAddress, resetEmailWindow),
+		viewLimiter:       newRateLimiter(1, viewWindow),
+	}
+	api.handler = api.cfg.routes("memory")
+	return api
+}
+
+// testUser is a user and an access token for them.
+type testUser struct {
+	database.User
+	token string
+}
+
+// createUser adds a user with the password "password", role and a verified
+// email address.
+func (api *testAPI) createUser(t *testing.T, email string, role database.Role) testUser {
+	t.Helper()
+	hash, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	user, err := api.db.CreateUser(database.CreateUserParams{Email: email, Password: hash})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := api.db.UpdateUserRole(user.ID, role); err != nil {
+		t.Fatalf("UpdateUserRole: %v", err)
+	}
+	if err := api.db.MarkEmailVerified(user.ID); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+	return api.userWithToken(t, user.ID)
+}
+
+// userWithToken loads a user and signs an access token for them as they are
+// now.
+func (api *testAPI) userWithToken(t *testing.T, id uuid.UUID) testUser {
+	t.Helper()
+	user, err := api.db.GetUser(id)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, string(user.Role), user.TokenVersion, testJWTSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return testUser{User: *user, token: token}
+}
+
+// createAPIKey gives user an API key with scopes and returns it.
+func (api *testAPI) createAPIKey(t *testing.T, user testUser, scopes ...database.APIKeyScope) string {
+	t.Helper()
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey: %v", err)
+	}
+	_, err = api.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  user.ID,
+		Name:    "test",
+		Prefix:  key[:len(auth.APIKeyPrefix)+8],
+		KeyHash: auth.HashToken(key),
+		Scopes:  scopes,
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return key
+}
+
+func (api *testAPI) createVideo(t *testing.T, owner testUser, title string, visibility database.Visibility) database.Video {
+	t.Helper()
+	video, err := api.db.CreateVideo(database.CreateVideoParams{
+		Title:      title,
+		UserID:     owner.ID,
+		Visibility: visibility,
+	})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+// newRequest builds a request with a JSON body, or body as is when it's a
+// string or []byte. An ApiKey credential is sent as one, anything else as a
+// bearer token.
+func newRequest(method, target, credential string, body any) *http.Request {
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			panic(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, r)
+	switch {
+	case credential == "":
+	case strings.HasPrefix(credential, auth.APIKeyPrefix):
+		req.Header.Set("Authorization", "ApiKey "+credential)
+	default:
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	return req
+}
+
+func (api *testAPI) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func (api *testAPI) do(method, target, credential string, body any) *httptest.ResponseRecorder {
+	return api.serve(newRequest(method, target, credential, body))
+}
+
+type sessionResponse struct {
+	Token          string `json:"token"`
+	RefreshToken   string `json:"refresh_token"`
+	TOTPRequired   bool   `json:"totp_required"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+func (api *testAPI) login(t *testing.T, email string) sessionResponse {
+	t.Helper()
+	rec := api.do("POST", "/api/login", "", map[string]string{"email": email, "password": "password"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", rec.Code, rec.Body)
+	}
+	return decodeBody[sessionResponse](t, rec)
+}
+
+// multipartBody builds a form with one file field and returns it with its
+// Content-Type.
+func multipartBody(t *testing.T, field, contentType string, data []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="upload"`)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	part.Write(data)
+	if err := form.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return body, form.FormDataContentType()
+}
+
+func (api *testAPI) upload(t *testing.T, target, token, field, contentType string, data []byte) *http.Response {
+	t.Helper()
+	body, formType := multipartBody(t, field, contentType, data)
+	req := newRequest("POST", target, token, body.Bytes())
+	req.Header.Set("Content-Type", formType)
+	return api.serve(req).Result()
+}
+
+// statusCase is a request and the status it should get.
+type statusCase struct {
+	name       string
+	method     string
+	target     string
+	credential string
+	body       any
+	want       int
+}
+
+func runStatusCases(t *testing.T, api *testAPI, cases []statusCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := api.do(tc.method, tc.target, tc.credential, tc.body)
+			if rec.Code != tc.want {
+				t.Errorf("%s %s = %d, want %d: %s", tc.method, tc.target, rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
+
+func decodeBody[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	err := json.Unmarshal(rec.Body.Bytes(), &v)
+	if err != nil {
+		t.Fatalf("couldn't decode response %q: %v", rec.Body, err)
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const migrateUsage = `usage: tubely migrate <command>
+
+commands:
+  status         list migrations and whether they are applied
+  up             apply all pending migrations
+  down [steps]   revert the latest migration, or the latest steps migrations
+  create <name>  add empty up and down files for a new migration`
+
+// runMigrate implements the migrate subcommand and returns the exit code.
+func runMigrate(dbURL string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		paths, err := database.CreateMigration(database.MigrationsDir, args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't create migration: %v\n", err)
+			return 1
+		}
+		for _, p := range paths {
+			fmt.Println("Created", p)
+		}
+		return 0
+	}
+
+	db, err := database.Open(dbURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't read migration status: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", s.Migration, applied)
+		}
+		w.Flush()
+	case "up":
+		applied, err := db.MigrateUp()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't migrate: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Already up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "steps must be a positive number")
+				return 2
+			}
+		}
+		reverted, err := db.MigrateDown(steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't migrate: %v\n", err)
+			return 1
+		}
+		if len(reverted) == 0 {
+			fmt.Println("Nothing to revert")
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
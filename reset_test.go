@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerReset(t *testing.T) {
+	api := newTestAPI(t)
+	api.createUser(t, "user@example.com", database.RoleCreator)
+
+	api.cfg.platform = "prod"
+	runStatusCases(t, api, []statusCase{
+		{"outside dev", "POST", "/admin/reset", "", nil, http.StatusForbidden},
+	})
+	if _, err := api.db.GetUserByEmail("user@example.com"); err != nil {
+		t.Fatalf("reset outside dev deleted users: %v", err)
+	}
+
+	api.cfg.platform = "dev"
+	runStatusCases(t, api, []statusCase{
+		{"dev", "POST", "/admin/reset", "", nil, http.StatusOK},
+	})
+	if _, err := api.db.GetUserByEmail("user@example.com"); err == nil {
+		t.Error("reset left users behind")
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type permission string
+
+const (
+	permUploadVideos   permission = "upload_videos"
+	permModerateVideos permission = "moderate_videos"
+	permManageUsers    permission = "manage_users"
+)
+
+var rolePermissions = map[database.Role][]permission{
+	database.RoleViewer:    {},
+	database.RoleCreator:   {permUploadVideos},
+	database.RoleModerator: {permUploadVideos, permModerateVideos},
+	database.RoleAdmin:     {permUploadVideos, permModerateVideos, permManageUsers},
+}
+
+func roleCan(role database.Role, perm permission) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
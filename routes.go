@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// routes registers every endpoint. The s3 backend's files are served by
+// CloudFront, so /assets/ only serves the local assets directory for it.
+func (cfg *apiConfig) routes(storageBackend string) *http.ServeMux {
+	mux := http.NewServeMux()
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.filepathRoot)))
+	mux.Handle("/app/", appHandler)
+
+	if storageBackend == "s3" {
+		assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(cfg.assetsRoot)))
+		mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	} else {
+		mux.Handle("GET /assets/{key...}", noCacheMiddleware(cfg.withAuth(cfg.handlerAssetsGet, scope(database.ScopeVideosRead))))
+		mux.Handle("GET /assets/t/{expires}/{signature}/{key...}", noCacheMiddleware(http.HandlerFunc(cfg.handlerAssetsGetSigned)))
+	}
+
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/totp", cfg.handlerLoginTOTP)
+	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+
+	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/verify_email", cfg.handlerVerifyEmail)
+	mux.Handle("POST /api/verify_email/send", cfg.withAuth(cfg.handlerVerifyEmailSend, signedIn))
+	mux.HandleFunc("POST /api/password_reset", cfg.handlerPasswordReset)
+	mux.HandleFunc("POST /api/password_reset/send", cfg.handlerPasswordResetSend)
+	mux.Handle("POST /api/totp/enroll", cfg.withAuth(cfg.handlerTOTPEnroll, loginOnly))
+	mux.Handle("POST /api/totp/confirm", cfg.withAuth(cfg.handlerTOTPConfirm, loginOnly))
+	mux.Handle("POST /api/totp/disable", cfg.withAuth(cfg.handlerTOTPDisable, loginOnly))
+	mux.Handle("POST /api/api_keys", cfg.withAuth(cfg.handlerAPIKeysCreate, loginOnly))
+	mux.Handle("GET /api/api_keys", cfg.withAuth(cfg.handlerAPIKeysList, loginOnly))
+	mux.Handle("DELETE /api/api_keys/{keyID}", cfg.withAuth(cfg.handlerAPIKeyRevoke, loginOnly))
+
+	mux.Handle("POST /api/videos", cfg.withAuth(cfg.handlerVideoMetaCreate, scope(database.ScopeVideosUpload), can(permUploadVideos), verifiedEmail))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.withAuth(cfg.handlerUploadThumbnail, scope(database.ScopeVideosUpload), can(permUploadVideos), verifiedEmail, ownsVideo))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.withAuth(cfg.handlerUploadVideo, scope(database.ScopeVideosUpload), can(permUploadVideos), verifiedEmail, ownsVideo))
+	mux.Handle("POST /api/video_upload/{videoID}/presign", cfg.withAuth(cfg.handlerVideoUploadPresign, scope(database.ScopeVideosUpload), can(permUploadVideos), verifiedEmail, ownsVideo))
+	mux.Handle("POST /api/video_upload/{videoID}/complete", cfg.withAuth(cfg.handlerVideoUploadComplete, scope(database.ScopeVideosUpload), can(permUploadVideos), verifiedEmail, ownsVideo))
+	mux.Handle("GET /api/videos", cfg.withAuth(cfg.handlerVideosRetrieve, scope(database.ScopeVideosRead), signedIn))
+	mux.Handle("GET /api/videos/{videoID}", cfg.withAuth(cfg.handlerVideoGet, scope(database.ScopeVideosRead)))
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.withAuth(cfg.handlerVideoMetaUpdate, scope(database.ScopeVideosWrite), ownsVideo))
+	mux.Handle("GET /api/feed", cfg.withAuth(cfg.handlerFeed, scope(database.ScopeVideosRead)))
+	mux.Handle("GET /api/search", cfg.withAuth(cfg.handlerSearch, scope(database.ScopeVideosRead)))
+	// This was used for the in-memory thumbnail storage
+	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.withAuth(cfg.handlerVideoMetaDelete, scope(database.ScopeVideosWrite), ownsVideo))
+
+	mux.Handle("GET /api/jobs/{jobID}", cfg.withAuth(cfg.handlerJobGet, scope(database.ScopeVideosRead, database.ScopeVideosUpload), signedIn))
+
+	mux.HandleFunc("OPTIONS /api/tus", cfg.handlerTusOptions)
+	mux.Handle("POST /api/tus", cfg.withAuth(cfg.handlerTusCreate, scope(database.ScopeVideosUpload), can(permUploadVideos), verifiedEmail))
+	mux.Handle("HEAD /api/tus/{uploadID}", cfg.withAuth(cfg.handlerTusHead, scope(database.ScopeVideosUpload), signedIn))
+	mux.Handle("PATCH /api/tus/{uploadID}", cfg.withAuth(cfg.handlerTusPatch, scope(database.ScopeVideosUpload), signedIn))
+	mux.Handle("DELETE /api/tus/{uploadID}", cfg.withAuth(cfg.handlerTusDelete, scope(database.ScopeVideosUpload), signedIn))
+
+	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("GET /admin/users", cfg.withAuth(cfg.handlerAdminUsersList, loginOnly, can(permManageUsers)))
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.withAuth(cfg.handlerAdminUserRole, loginOnly, can(permManageUsers)))
+	mux.Handle("DELETE /admin/videos/{videoID}", cfg.withAuth(cfg.handlerAdminVideoDelete, loginOnly, can(permModerateVideos)))
+	mux.Handle("POST /admin/videos/{videoID}/hide", cfg.withAuth(cfg.handlerAdminVideoHide, loginOnly, can(permModerateVideos)))
+	mux.Handle("POST /admin/videos/{videoID}/unhide", cfg.withAuth(cfg.handlerAdminVideoUnhide, loginOnly, can(permModerateVideos)))
+
+	return mux
+}
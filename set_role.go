@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const setRoleUsage = `usage: tubely set-role <email> <role>
+
+role is one of viewer, creator, moderator or admin`
+
+// runSetRole implements the set-role subcommand, which is how the first admin
+// gets promoted, and returns the exit code.
+func runSetRole(dbURL string, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, setRoleUsage)
+		return 2
+	}
+	email, role := args[0], database.Role(args[1])
+	if !role.Valid() {
+		fmt.Fprintln(os.Stderr, setRoleUsage)
+		return 2
+	}
+
+	db, err := database.NewClient(dbURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	user, err := db.GetUserByEmail(email)
+	if errors.Is(err, database.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "No user with email %s\n", email)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get user: %v\n", err)
+		return 1
+	}
+	err = db.UpdateUserRole(user.ID, role)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't update role: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s now has the %s role\n", email, role)
+	return 0
+}
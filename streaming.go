@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int // kbit/s
+}
+
+// streamingLadder is ordered from the highest to the lowest quality. Height
+// is the short side of the frame, so portrait videos get the same ladder.
+var streamingLadder = []rendition{
+	{Name: "1080p", Height: 1080, VideoBitrate: 5000},
+	{Name: "720p", Height: 720, VideoBitrate: 2800},
+	{Name: "480p", Height: 480, VideoBitrate: 1400},
+	{Name: "360p", Height: 360, VideoBitrate: 800},
+}
+
+const (
+	streamingSegmentSeconds = 6
+	streamingAudioBitrate   = 128 // kbit/s
+
+	hlsManifestName  = "master.m3u8"
+	dashManifestName = "manifest.mpd"
+)
+
+// streamingManifests holds the storage keys of the published manifests.
+// A key is empty when that format is disabled.
+type streamingManifests struct {
+	HLSKey  string
+	DASHKey string
+	// Prefix is the directory the manifests and segments were stored under.
+	Prefix string
+}
+
+// pickRenditions drops the renditions that would upscale the source. The
+// lowest rung is always kept so tiny sources still get a manifest.
+func pickRenditions(width, height int) []rendition {
+	short := min(width, height)
+	picked := []rendition{}
+	for _, r := range streamingLadder {
+		if r.Height <= short {
+			picked = append(picked, r)
+		}
+	}
+	if len(picked) == 0 {
+		picked = append(picked, streamingLadder[len(streamingLadder)-1])
+	}
+	return picked
+}
+
+// renditionSize scales the source so its short side matches r.Height,
+// keeping both dimensions even as libx264 requires.
+func renditionSize(r rendition, width, height int) (int, int) {
+	even := func(n int) int { return (n + 1) &^ 1 }
+	if width >= height {
+		return even(width * r.Height / height), r.Height
+	}
+	return r.Height, even(height * r.Height / width)
+}
+
+// packageStreams transcodes filePath into a bitrate ladder of fragmented
+// MP4 (CMAF) segments under outDir, with a DASH manifest.mpd and, when
+// withHLS is set, HLS playlists pointing at the same segments.
+func packageStreams(ctx context.Context, filePath, outDir string, info videoInfo, withHLS bool) error {
+	renditions := pickRenditions(info.Width, info.Height)
+
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(renditions))
+	for i := range renditions {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	for i, r := range renditions {
+		w, h := renditionSize(r, info.Width, info.Height)
+		fmt.Fprintf(&filter, ";[v%d]scale=%d:%d[v%dout]", i, w, h, i)
+	}
+
+	args := []string{
+		"-i", filePath,
+		"-filter_complex", filter.String(),
+	}
+	for i, r := range renditions {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			fmt.Sprintf("-c:v:%d", i), "libx264",
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+		)
+	}
+	adaptationSets := "id=0,streams=v"
+	if info.HasAudio {
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "aac",
+			"-b:a", fmt.Sprintf("%dk", streamingAudioBitrate),
+			"-ac", "2",
+		)
+		adaptationSets += " id=1,streams=a"
+	}
+	args = append(args,
+		"-preset", "veryfast",
+		"-g", "48",
+		"-keyint_min", "48",
+		"-sc_threshold", "0",
+		"-f", "dash",
+		"-seg_duration", fmt.Sprint(streamingSegmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+	)
+	if withHLS {
+		args = append(args, "-hls_playlist", "1")
+	}
+	args = append(args, filepath.Join(outDir, dashManifestName))
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("ffmpeg error: %v, details: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// uploadDir stores every file under dir with the same relative path below
+// prefix.
+func (cfg *apiConfig) uploadDir(ctx context.Context, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		key := path.Join(prefix, filepath.ToSlash(rel))
+		return cfg.storage.Put(ctx, key, f, streamingContentType(key))
+	})
+}
+
+func streamingContentType(key string) string {
+	switch path.Ext(key) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".m4s":
+		return "video/iso.segment"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// streamsVersionPrefix is where publishStreams stores one version of a
+// video's streams.
+func streamsVersionPrefix(videoID uuid.UUID, version string) string {
+	return "streams/" + videoID.String() + "/" + version + "/"
+}
+
+// streamPrefix returns the directory holding a manifest and its segments.
+func streamPrefix(manifestKey string) string {
+	return path.Dir(manifestKey) + "/"
+}
+
+// deleteStreams removes the streams stored under prefix, except anything
+// under keep. Streams used to be stored straight under streams/<videoID>/,
+// so an old prefix can contain the new one.
+func (cfg *apiConfig) deleteStreams(ctx context.Context, prefix, keep string) {
+	objs, err := cfg.storage.List(ctx, prefix)
+	if err != nil {
+		log.Printf("Couldn't list streams under %s: %v", prefix, err)
+		return
+	}
+	for _, obj := range objs {
+		if keep != "" && strings.HasPrefix(obj.Key, keep) {
+			continue
+		}
+		err := cfg.storage.Delete(ctx, obj.Key)
+		if err != nil {
+			log.Printf("Couldn't delete %s: %v", obj.Key, err)
+		}
+	}
+}
+
+// publishStreams packages filePath for adaptive streaming and uploads it
+// under streams/<videoID>/<version>/. Each version gets its own prefix so
+// the streams a video currently points at stay playable until the caller
+// has switched it over and deleted them.
+func (cfg *apiConfig) publishStreams(ctx context.Context, videoID uuid.UUID, version, filePath string) (streamingManifests, error) {
+	info, err := getVideoInfo(ctx, filePath)
+	if err != nil {
+		return streamingManifests{}, err
+	}
+
+	outDir, err := os.MkdirTemp("", "tubely-streams-*")
+	if err != nil {
+		return streamingManifests{}, err
+	}
+	defer os.RemoveAll(outDir)
+
+	err = packageStreams(ctx, filePath, outDir, info, cfg.hlsEnabled)
+	if err != nil {
+		return streamingManifests{}, err
+	}
+	if !cfg.dashEnabled {
+		err = os.Remove(filepath.Join(outDir, dashManifestName))
+		if err != nil {
+			return streamingManifests{}, err
+		}
+	}
+
+	prefix := streamsVersionPrefix(videoID, version)
+	err = cfg.uploadDir(ctx, outDir, prefix)
+	if err != nil {
+		return streamingManifests{}, err
+	}
+
+	manifests := streamingManifests{Prefix: prefix}
+	if cfg.hlsEnabled {
+		manifests.HLSKey = prefix + hlsManifestName
+	}
+	if cfg.dashEnabled {
+		manifests.DASHKey = prefix + dashManifestName
+	}
+	return manifests, nil
+}
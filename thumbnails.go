@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnail"
+	"github.com/google/uuid"
+)
+
+type thumbnailMode string
+
+const (
+	thumbnailModeTimestamp thumbnailMode = "timestamp"
+	thumbnailModeScene     thumbnailMode = "scene"
+)
+
+// sceneChangeThreshold is how different (0-1) a frame must be from the one
+// before it to count as a scene change.
+const sceneChangeThreshold = 0.4
+
+// storeThumbnail decodes an image, resizes it to every thumbnail size and
+// stores each size as JPEG, and as WebP when ffmpeg can encode it, under a
+// random prefix. It is shared by user uploads and thumbnails extracted from
+// videos. The returned keys are everything that was stored, so callers can
+// clean up.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, body io.Reader) (database.Thumbnail, []string, error) {
+	img, err := thumbnail.Decode(body, thumbnail.DefaultLimits)
+	if err != nil {
+		return database.Thumbnail{}, nil, err
+	}
+
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return database.Thumbnail{}, nil, err
+	}
+	prefix := "thumbnails/" + base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	result := database.Thumbnail{Bucket: cfg.s3Bucket}
+	keys := []string{}
+	for _, size := range thumbnail.SizesFor(img.Bounds().Dx()) {
+		resized := thumbnail.Resize(img, size.Width)
+		bounds := resized.Bounds()
+
+		var jpegBuf bytes.Buffer
+		err = thumbnail.EncodeJPEG(&jpegBuf, resized)
+		if err != nil {
+			cfg.deleteKeys(ctx, keys)
+			return database.Thumbnail{}, nil, err
+		}
+		jpegKey := fmt.Sprintf("%s/%s.jpg", prefix, size.Name)
+		err = cfg.storage.Put(ctx, jpegKey, &jpegBuf, "image/jpeg")
+		if err != nil {
+			cfg.deleteKeys(ctx, keys)
+			return database.Thumbnail{}, nil, err
+		}
+		keys = append(keys, jpegKey)
+
+		webpKey := ""
+		if cfg.webpEnabled {
+			var webpBuf bytes.Buffer
+			err = thumbnail.EncodeWebP(ctx, &webpBuf, resized)
+			if err != nil {
+				cfg.deleteKeys(ctx, keys)
+				return database.Thumbnail{}, nil, err
+			}
+			webpKey = fmt.Sprintf("%s/%s.webp", prefix, size.Name)
+			err = cfg.storage.Put(ctx, webpKey, &webpBuf, "image/webp")
+			if err != nil {
+				cfg.deleteKeys(ctx, keys)
+				return database.Thumbnail{}, nil, err
+			}
+			keys = append(keys, webpKey)
+		}
+
+		result.Sizes = append(result.Sizes, database.ThumbnailSize{
+			Name:    size.Name,
+			Width:   bounds.Dx(),
+			Height:  bounds.Dy(),
+			JPEGKey: jpegKey,
+			WebPKey: webpKey,
+		})
+	}
+
+	// Default to the medium JPEG, the most widely supported rendition that
+	// still looks fine on a large screen.
+	result.SrcKey = result.Sizes[min(1, len(result.Sizes)-1)].JPEGKey
+	return result, keys, nil
+}
+
+func (cfg *apiConfig) deleteKeys(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		err := cfg.storage.Delete(ctx, key)
+		if err != nil {
+			log.Printf("Couldn't delete %s: %v", key, err)
+		}
+	}
+}
+
+// extractThumbnail grabs a representative frame from a video with ffmpeg and
+// writes it next to the video as a PNG. It returns the image path.
+func (cfg *apiConfig) extractThumbnail(ctx context.Context, filePath string) (string, error) {
+	outPath := filePath + ".thumbnail.png"
+
+	if cfg.thumbnailMode == thumbnailModeScene {
+		err := runFrameGrab(ctx, outPath,
+			"-i", filePath,
+			"-vf", fmt.Sprintf("select='gt(scene,%g)'", sceneChangeThreshold),
+			"-vsync", "vfr",
+		)
+		if err == nil {
+			return outPath, nil
+		}
+		// No scene change found, use the configured timestamp instead
+	}
+
+	err := runFrameGrab(ctx, outPath,
+		"-ss", fmt.Sprintf("%.3f", cfg.thumbnailOffset.Seconds()),
+		"-i", filePath,
+	)
+	if err != nil {
+		// The video is shorter than the offset, use its first frame
+		err = runFrameGrab(ctx, outPath, "-i", filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+func runFrameGrab(ctx context.Context, outPath string, inputArgs ...string) error {
+	args := append([]string{"-y"}, inputArgs...)
+	args = append(args, "-frames:v", "1", outPath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("ffmpeg error: %v, details: %s", err, stderr.String())
+	}
+
+	// ffmpeg exits cleanly without writing anything if no frame matched
+	info, err := os.Stat(outPath)
+	if err != nil || info.Size() == 0 {
+		return errors.New("ffmpeg didn't produce a frame")
+	}
+	return nil
+}
+
+func parseThumbnailOffset(v string) (time.Duration, error) {
+	if v == "" {
+		return 2 * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("offset can't be negative")
+	}
+	return d, nil
+}
+
+// autoThumbnail extracts and stores a thumbnail for a video that doesn't
+// have one yet. Failures are logged and skipped: a missing thumbnail isn't
+// worth failing the whole processing job over. It returns nil when nothing
+// was stored.
+func (cfg *apiConfig) autoThumbnail(ctx context.Context, videoID uuid.UUID, filePath string) (*database.Thumbnail, []string) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't get video %s for thumbnail extraction: %v", videoID, err)
+		return nil, nil
+	}
+	if video.Thumbnail != nil {
+		return nil, nil
+	}
+
+	thumbnailPath, err := cfg.extractThumbnail(ctx, filePath)
+	if err != nil {
+		log.Printf("Couldn't extract thumbnail for video %s: %v", videoID, err)
+		return nil, nil
+	}
+	defer os.Remove(thumbnailPath)
+
+	f, err := os.Open(thumbnailPath)
+	if err != nil {
+		log.Printf("Couldn't open thumbnail for video %s: %v", videoID, err)
+		return nil, nil
+	}
+	defer f.Close()
+
+	thumb, keys, err := cfg.storeThumbnail(ctx, f)
+	if err != nil {
+		log.Printf("Couldn't store thumbnail for video %s: %v", videoID, err)
+		return nil, nil
+	}
+	return &thumb, keys
+}
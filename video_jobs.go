@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobPollInterval = 5 * time.Second
+	videoJobTimeout = 30 * time.Minute
+	jobRetryBackoff = 30 * time.Second
+	// A running job's lease is extended every jobLeaseRenewal. Once it has
+	// gone jobLeaseDuration without one, its worker is assumed dead and the
+	// job is requeued.
+	jobLeaseDuration = 2 * time.Minute
+	jobLeaseRenewal  = jobLeaseDuration / 4
+)
+
+// errVideoDeleted fails a job for good: retrying can't bring the video back.
+var errVideoDeleted = errors.New("video no longer exists")
+
+// newRawUploadKey returns a fresh storage key for an unprocessed upload.
+func newRawUploadKey(videoID uuid.UUID) (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("raw/%s/%s.mp4", videoID, hex.EncodeToString(randomBytes)), nil
+}
+
+func (cfg *apiConfig) enqueueVideoJob(videoID, userID uuid.UUID, inputKey, contentType string) (database.Job, error) {
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:     videoID,
+		UserID:      userID,
+		InputKey:    inputKey,
+		ContentType: contentType,
+		MaxAttempts: cfg.jobMaxAttempts,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	err = cfg.setProcessingStatus(videoID, database.ProcessingStatusPending)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	cfg.notifyVideoWorkers()
+	return job, nil
+}
+
+// setProcessingStatus updates the status of a video that isn't ready yet.
+// A ready video keeps playing its current file while a new upload is
+// processed, so it stays ready and only the job reports how that goes.
+func (cfg *apiConfig) setProcessingStatus(videoID uuid.UUID, status database.ProcessingStatus) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ProcessingStatus == database.ProcessingStatusReady {
+		return nil
+	}
+	return cfg.db.UpdateVideoProcessingStatus(videoID, status)
+}
+
+// notifyVideoWorkers wakes up an idle worker without waiting for the next
+// poll. It never blocks; if a wake-up is already pending that one is enough.
+func (cfg *apiConfig) notifyVideoWorkers() {
+	select {
+	case cfg.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+func (cfg *apiConfig) startVideoWorkers(ctx context.Context, n int) error {
+	err := cfg.db.RequeueExpiredJobs(time.Now())
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		go cfg.runVideoWorker(ctx)
+	}
+	go cfg.runJobRequeuer(ctx)
+	return nil
+}
+
+// runJobRequeuer puts jobs whose worker stopped, here or in another
+// instance, back in the queue.
+func (cfg *apiConfig) runJobRequeuer(ctx context.Context) {
+	ticker := time.NewTicker(jobLeaseRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := cfg.db.RequeueExpiredJobs(time.Now())
+		if err != nil {
+			log.Printf("Couldn't requeue expired video jobs: %v", err)
+		}
+	}
+}
+
+// errJobLeaseLost cancels a job whose lease ran out and that another
+// worker may have claimed since.
+var errJobLeaseLost = errors.New("lost the job lease")
+
+// keepJobLease extends the lease on job until ctx is done. If the lease
+// was lost it cancels the job with errJobLeaseLost, so two workers don't
+// keep processing the same job.
+func (cfg *apiConfig) keepJobLease(ctx context.Context, cancel context.CancelCauseFunc, job database.Job) {
+	ticker := time.NewTicker(jobLeaseRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := cfg.db.ExtendJobLease(job.ID, job.Attempts, time.Now().Add(jobLeaseDuration))
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("Lost the lease on video job %s, stopping it", job.ID)
+			cancel(errJobLeaseLost)
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't extend the lease on video job %s: %v", job.ID, err)
+		}
+	}
+}
+
+func (cfg *apiConfig) runVideoWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := cfg.db.ClaimNextJob(time.Now().Add(jobLeaseDuration))
+		if err != nil {
+			log.Printf("Couldn't claim video job: %v", err)
+		}
+		if job != nil {
+			cfg.runVideoJob(ctx, *job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.jobWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) runVideoJob(ctx context.Context, job database.Job) {
+	err := cfg.setProcessingStatus(job.VideoID, database.ProcessingStatusProcessing)
+	if err != nil {
+		log.Printf("Couldn't mark video %s as processing: %v", job.VideoID, err)
+	}
+
+	leaseCtx, cancelLease := context.WithCancelCause(ctx)
+	defer cancelLease(nil)
+	go cfg.keepJobLease(leaseCtx, cancelLease, job)
+
+	jobCtx, cancel := context.WithTimeout(leaseCtx, videoJobTimeout)
+	defer cancel()
+
+	err = cfg.processVideoJob(jobCtx, job)
+	if errors.Is(context.Cause(leaseCtx), errJobLeaseLost) {
+		// The job is someone else's now
+		return
+	}
+	if err == nil {
+		if err := cfg.db.CompleteJob(job.ID); err != nil {
+			log.Printf("Couldn't mark job %s as completed: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Video job %s failed on attempt %d/%d: %v", job.ID, job.Attempts, job.MaxAttempts, err)
+
+	if job.Attempts < job.MaxAttempts && !errors.Is(err, errVideoDeleted) {
+		runAt := time.Now().Add(time.Duration(job.Attempts*job.Attempts) * jobRetryBackoff)
+		if err := cfg.db.RetryJob(job.ID, err.Error(), runAt); err != nil {
+			log.Printf("Couldn't reschedule job %s: %v", job.ID, err)
+		}
+		if err := cfg.setProcessingStatus(job.VideoID, database.ProcessingStatusPending); err != nil {
+			log.Printf("Couldn't mark video %s as pending: %v", job.VideoID, err)
+		}
+		return
+	}
+
+	if err := cfg.db.FailJob(job.ID, err.Error()); err != nil {
+		log.Printf("Couldn't mark job %s as failed: %v", job.ID, err)
+	}
+	// Nothing will read the raw upload again
+	if err := cfg.storage.Delete(ctx, job.InputKey); err != nil {
+		log.Printf("Couldn't delete raw upload %s: %v", job.InputKey, err)
+	}
+	if errors.Is(err, errVideoDeleted) {
+		return
+	}
+	if err := cfg.setProcessingStatus(job.VideoID, database.ProcessingStatusFailed); err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", job.VideoID, err)
+	}
+}
+
+// processVideoJob runs the fast start and aspect ratio steps on a raw upload
+// and publishes the result on the video. If it fails, everything it stored
+// is deleted again so retries don't leave orphaned files behind.
+func (cfg *apiConfig) processVideoJob(ctx context.Context, job database.Job) (err error) {
+	var stored []string
+	var streams string
+	defer func() {
+		if err == nil {
+			return
+		}
+		// ctx may be the reason we failed
+		cleanupCtx := context.WithoutCancel(ctx)
+		cfg.deleteKeys(cleanupCtx, stored)
+		if streams != "" {
+			cfg.deleteStreams(cleanupCtx, streams, "")
+		}
+	}()
+
+	// Don't spend ffmpeg time on a video that was deleted while queued
+	_, err = cfg.db.GetVideo(job.VideoID)
+	if errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("%w: %s", errVideoDeleted, job.VideoID)
+	}
+	if err != nil {
+		return err
+	}
+
+	body, _, err := cfg.storage.Get(ctx, job.InputKey)
+	if err != nil {
+		return fmt.Errorf("couldn't read raw upload: %w", err)
+	}
+	defer body.Close()
+
+	videoFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
+	if err != nil {
+		return fmt.Errorf("couldn't create temporary file: %w", err)
+	}
+	defer os.Remove(videoFile.Name())
+	defer videoFile.Close()
+
+	_, err = io.Copy(videoFile, body)
+	if err != nil {
+		return fmt.Errorf("couldn't copy raw upload: %w", err)
+	}
+
+	// Process the video for fast start
+	processedPath, err := processVideoForFastStart(ctx, videoFile.Name())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(processedPath)
+
+	processedFile, err := os.Open(processedPath)
+	if err != nil {
+		return fmt.Errorf("couldn't open processed video: %w", err)
+	}
+	defer processedFile.Close()
+
+	// Determine the aspect ratio of the video
+	aspectRatio, err := getVideoAspectRatio(ctx, processedPath)
+	if err != nil {
+		return fmt.Errorf("couldn't determine aspect ratio: %w", err)
+	}
+	var prefix string
+	switch aspectRatio {
+	case "16:9":
+		prefix = "landscape"
+	case "9:16":
+		prefix = "portrait"
+	default:
+		prefix = "other"
+	}
+
+	// Generate a random 32-byte hex string for the storage key
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/%s.mp4", prefix, hex.EncodeToString(randomBytes))
+
+	stored = append(stored, key)
+	err = cfg.storage.Put(ctx, key, processedFile, job.ContentType)
+	if err != nil {
+		return fmt.Errorf("couldn't store processed video: %w", err)
+	}
+
+	var thumb *database.Thumbnail
+	var thumbnailKeys []string
+	if cfg.thumbnailMode != "" {
+		thumb, thumbnailKeys = cfg.autoThumbnail(ctx, job.VideoID, processedPath)
+		stored = append(stored, thumbnailKeys...)
+	}
+
+	manifests := streamingManifests{}
+	if cfg.hlsEnabled || cfg.dashEnabled {
+		streams = streamsVersionPrefix(job.VideoID, job.ID.String())
+		manifests, err = cfg.publishStreams(ctx, job.VideoID, job.ID.String(), processedPath)
+		if err != nil {
+			return fmt.Errorf("couldn't package adaptive streams: %w", err)
+		}
+	}
+
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("%w: %s", errVideoDeleted, job.VideoID)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The owner may have uploaded their own thumbnail while we were busy
+	if thumb != nil {
+		if video.Thumbnail == nil {
+			video.Thumbnail = thumb
+		} else {
+			cfg.deleteKeys(ctx, thumbnailKeys)
+		}
+	}
+
+	video.VideoBucket = nil
+	if cfg.s3Bucket != "" {
+		video.VideoBucket = &cfg.s3Bucket
+	}
+	oldVideoKey := ""
+	if video.VideoKey != nil && *video.VideoKey != key {
+		oldVideoKey = *video.VideoKey
+	}
+	video.VideoKey = &key
+	video.AspectRatio = &prefix
+	oldStreams := ""
+	if video.HLSManifestKey != nil {
+		oldStreams = streamPrefix(*video.HLSManifestKey)
+	} else if video.DASHManifestKey != nil {
+		oldStreams = streamPrefix(*video.DASHManifestKey)
+	}
+	video.HLSManifestKey = nil
+	if manifests.HLSKey != "" {
+		video.HLSManifestKey = &manifests.HLSKey
+	}
+	video.DASHManifestKey = nil
+	if manifests.DASHKey != "" {
+		video.DASHManifestKey = &manifests.DASHKey
+	}
+	video.ProcessingStatus = database.ProcessingStatusReady
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		return err
+	}
+
+	// The previous file and streams stayed up until the video stopped
+	// pointing at them
+	if oldVideoKey != "" {
+		cfg.deleteKeys(ctx, []string{oldVideoKey})
+	}
+	if oldStreams != "" && oldStreams != manifests.Prefix {
+		cfg.deleteStreams(ctx, oldStreams, manifests.Prefix)
+	}
+
+	err = cfg.storage.Delete(ctx, job.InputKey)
+	if err != nil {
+		log.Printf("Couldn't delete raw upload %s: %v", job.InputKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// queueVideoJob stores data as a raw upload for video and queues a job for
+// it.
+func (api *testAPI) queueVideoJob(t *testing.T, video database.Video, data []byte) database.Job {
+	t.Helper()
+	inputKey, err := newRawUploadKey(video.ID)
+	if err != nil {
+		t.Fatalf("newRawUploadKey: %v", err)
+	}
+	err = api.storage.Put(context.Background(), inputKey, bytes.NewReader(data), "video/mp4")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	job, err := api.cfg.enqueueVideoJob(video.ID, video.UserID, inputKey, "video/mp4")
+	if err != nil {
+		t.Fatalf("enqueueVideoJob: %v", err)
+	}
+	return job
+}
+
+// runNextJob claims the next job and runs it the way a worker would.
+func (api *testAPI) runNextJob(t *testing.T) database.Job {
+	t.Helper()
+	job, err := api.db.ClaimNextJob(time.Now().Add(jobLeaseDuration))
+	if err != nil || job == nil {
+		t.Fatalf("ClaimNextJob = %v, %v", job, err)
+	}
+	api.cfg.runVideoJob(context.Background(), *job)
+	got, err := api.db.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	return got
+}
+
+// testMP4 returns a one second video made by ffmpeg, skipping the test when
+// it isn't installed.
+func testMP4(t *testing.T) []byte {
+	t.Helper()
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg isn't installed")
+	}
+	path := filepath.Join(t.TempDir(), "test.mp4")
+	out, err := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=size=320x180:rate=10", "-t", "1", "-pix_fmt", "yuv420p", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ffmpeg: %v: %s", err, out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return data
+}
+
+func TestVideoJobReplacesVideoFile(t *testing.T) {
+	data := testMP4(t)
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "reuploaded", database.VisibilityPublic)
+
+	oldKey := "landscape/old.mp4"
+	if err := api.storage.Put(context.Background(), oldKey, strings.NewReader("old"), "video/mp4"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	video.VideoKey = &oldKey
+	video.ProcessingStatus = database.ProcessingStatusReady
+	if err := api.db.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+
+	api.queueVideoJob(t, video, data)
+	if job := api.runNextJob(t); job.Status != database.JobStatusCompleted {
+		t.Fatalf("job status = %s, want %s: %v", job.Status, database.JobStatusCompleted, job.LastError)
+	}
+
+	got, err := api.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if got.VideoKey == nil || *got.VideoKey == oldKey {
+		t.Fatalf("video key = %v, want a new one", got.VideoKey)
+	}
+	if _, err := api.storage.Stat(context.Background(), *got.VideoKey); err != nil {
+		t.Errorf("new video file: %v", err)
+	}
+	if _, err := api.storage.Stat(context.Background(), oldKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("replaced video file wasn't deleted: %v", err)
+	}
+}
+
+func TestVideoJobForDeletedVideo(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+	video := api.createVideo(t, owner, "deleted while queued", database.VisibilityPublic)
+	queued := api.queueVideoJob(t, video, []byte("not a video"))
+	if err := api.db.DeleteVideo(video.ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+
+	// The first of three attempts already gives up
+	job := api.runNextJob(t)
+	if job.Status != database.JobStatusFailed {
+		t.Errorf("job status = %s, want %s", job.Status, database.JobStatusFailed)
+	}
+	if _, err := api.storage.Stat(context.Background(), queued.InputKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("raw upload wasn't deleted: %v", err)
+	}
+}
+
+func TestVideoJobLastAttemptFails(t *testing.T) {
+	tests := []struct {
+		name   string
+		status database.ProcessingStatus
+		want   database.ProcessingStatus
+	}{
+		// A re-upload that fails leaves the current file playing
+		{"ready video", database.ProcessingStatusReady, database.ProcessingStatusReady},
+		{"new video", database.ProcessingStatusPending, database.ProcessingStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			api.cfg.jobMaxAttempts = 1
+			owner := api.createUser(t, "owner@example.com", database.RoleCreator)
+			video := api.createVideo(t, owner, tt.name, database.VisibilityPublic)
+			if err := api.db.UpdateVideoProcessingStatus(video.ID, tt.status); err != nil {
+				t.Fatalf("UpdateVideoProcessingStatus: %v", err)
+			}
+
+			api.queueVideoJob(t, video, []byte("not a video"))
+			if got, err := api.db.GetVideo(video.ID); err != nil || got.ProcessingStatus != tt.status {
+				t.Errorf("queued video status = %s, %v, want %s", got.ProcessingStatus, err, tt.status)
+			}
+			job := api.runNextJob(t)
+			if job.Status != database.JobStatusFailed || job.LastError == nil {
+				t.Errorf("job status = %s with error %v, want %s with an error", job.Status, job.LastError, database.JobStatusFailed)
+			}
+			got, err := api.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			if got.ProcessingStatus != tt.want {
+				t.Errorf("video status = %s, want %s", got.ProcessingStatus, tt.want)
+			}
+		})
+	}
+}
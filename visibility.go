@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// errCDNVisibility is the reason the cdn delivery mode turns down videos
+// that aren't public.
+const errCDNVisibility = "DELIVERY_MODE=cdn serves videos from unsigned URLs, so only public videos are allowed; use DELIVERY_MODE=signed for private and unlisted videos"
+
+// visibilityAllowed reports whether videos may have visibility under the
+// configured delivery mode. Unsigned CDN URLs work for anyone who has them,
+// and stream URLs can be guessed from the video ID, so the cdn mode only
+// keeps public videos. An empty visibility is the private default.
+func (cfg *apiConfig) visibilityAllowed(visibility database.Visibility) bool {
+	return cfg.deliveryMode != "cdn" || visibility == database.VisibilityPublic
+}
+
+// checkCDNVisibility returns an error if a video that isn't public exists,
+// since the cdn delivery mode would hand out unsigned URLs to it.
+func (cfg *apiConfig) checkCDNVisibility() error {
+	for _, visibility := range []database.Visibility{database.VisibilityPrivate, database.VisibilityUnlisted} {
+		videos, _, err := cfg.db.QueryVideos(database.VideoQuery{
+			Visibility:    visibility,
+			IncludeHidden: true,
+			Limit:         1,
+		})
+		if err != nil {
+			return err
+		}
+		if len(videos) > 0 {
+			return fmt.Errorf("%s videos exist, e.g. %s", visibility, videos[0].ID)
+		}
+	}
+	return nil
+}
+
+// canViewVideo reports whether viewer, which is uuid.Nil for anonymous
+// visitors, may see a video. Unlisted and public videos are visible to
+// anyone who knows the ID, unless a moderator hid them.
+func canViewVideo(video database.Video, viewer uuid.UUID) bool {
+	if video.Visibility == database.VisibilityPrivate || video.Hidden {
+		return viewer != uuid.Nil && viewer == video.UserID
+	}
+	return true
+}